@@ -0,0 +1,80 @@
+package apikey
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.learning/middlewares"
+)
+
+type Handler interface {
+	Create(c echo.Context) (err error)
+	GetList(c echo.Context) (err error)
+	Revoke(c echo.Context) (err error)
+}
+
+type handler struct {
+	service Service
+}
+
+func NewHandler(service Service) Handler {
+	return handler{service}
+}
+
+func (h handler) Create(c echo.Context) (err error) {
+	userID, ok := middlewares.CurrentUserID(c)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, "User is not authenticated")
+	}
+
+	var req CreateAPIKey
+	if err = c.Bind(&req); err != nil {
+		return
+	}
+
+	key, err := h.service.CreateAPIKey(c.Request().Context(), userID, req)
+	if errors.Is(err, ErrNameRequired) || errors.Is(err, ErrInvalidScope) || errors.Is(err, ErrExpiryPassed) {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return
+	}
+
+	return c.JSON(http.StatusCreated, key)
+}
+
+func (h handler) GetList(c echo.Context) (err error) {
+	userID, ok := middlewares.CurrentUserID(c)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, "User is not authenticated")
+	}
+
+	keys, err := h.service.GetAPIKeyList(c.Request().Context(), userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "Failed to get API key list")
+	}
+
+	return c.JSON(http.StatusOK, keys)
+}
+
+func (h handler) Revoke(c echo.Context) (err error) {
+	userID, ok := middlewares.CurrentUserID(c)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, "User is not authenticated")
+	}
+
+	// Convert id to uint
+	var id uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &id); err != nil {
+		return c.JSON(http.StatusBadRequest, "Invalid ID format")
+	}
+
+	err = h.service.RevokeAPIKey(c.Request().Context(), userID, id)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, "API key not found")
+	}
+
+	return c.JSON(http.StatusNoContent, nil)
+}
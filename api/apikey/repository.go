@@ -0,0 +1,80 @@
+package apikey
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.learning/models"
+	"gorm.io/gorm"
+)
+
+type Repository interface {
+	CreateAPIKey(ctx context.Context, key *models.APIKey) error
+	GetAPIKeyList(ctx context.Context, userID uint) ([]models.APIKey, error)
+	GetAPIKeyByPrefix(ctx context.Context, prefix string) (*models.APIKey, error)
+	RevokeAPIKey(ctx context.Context, userID, id uint) error
+	TouchAPIKey(ctx context.Context, id uint, usedAt time.Time, interval time.Duration) error
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) *repository {
+	return &repository{db}
+}
+
+func (r *repository) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	err := r.db.WithContext(ctx).Create(key).Error
+	if err != nil {
+		return fmt.Errorf("failed to create API key: %w", err)
+	}
+	return nil
+}
+
+func (r *repository) GetAPIKeyList(ctx context.Context, userID uint) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at DESC").Find(&keys).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API key list: %w", err)
+	}
+	return keys, nil
+}
+
+// GetAPIKeyByPrefix loads the key with its owner. The owner is left empty when the user is soft-deleted.
+func (r *repository) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	var key models.APIKey
+	err := r.db.WithContext(ctx).Joins("User").Where("api_keys.prefix = ?", prefix).First(&key).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("API key not found")
+		}
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+	return &key, nil
+}
+
+func (r *repository) RevokeAPIKey(ctx context.Context, userID, id uint) error {
+	result := r.db.WithContext(ctx).Model(&models.APIKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke API key: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("API key not found")
+	}
+	return nil
+}
+
+// TouchAPIKey records usage, writing at most once per interval to keep authentication cheap
+func (r *repository) TouchAPIKey(ctx context.Context, id uint, usedAt time.Time, interval time.Duration) error {
+	err := r.db.WithContext(ctx).Model(&models.APIKey{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", id, usedAt.Add(-interval)).
+		UpdateColumn("last_used_at", usedAt).Error
+	if err != nil {
+		return fmt.Errorf("failed to update API key usage: %w", err)
+	}
+	return nil
+}
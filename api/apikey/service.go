@@ -0,0 +1,186 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.learning/models"
+	"go.learning/tracing"
+)
+
+const (
+	// keyPrefix marks strings as API keys issued by this service, e.g. gl_1a2b3c4d5e6f_<secret>
+	keyPrefix = "gl"
+
+	// lastUsedInterval limits how often authentication writes last_used_at
+	lastUsedInterval = time.Minute
+)
+
+var (
+	ErrInvalidKey   = errors.New("invalid API key")
+	ErrInvalidScope = errors.New("invalid scope")
+	ErrNameRequired = errors.New("name is required")
+	ErrExpiryPassed = errors.New("expires_at must be in the future")
+)
+
+type Service interface {
+	CreateAPIKey(ctx context.Context, userID uint, req CreateAPIKey) (*CreateAPIKeyResponse, error)
+	GetAPIKeyList(ctx context.Context, userID uint) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, userID, id uint) error
+	Authenticate(ctx context.Context, rawKey string) (*models.APIKey, error)
+}
+
+type service struct {
+	repository Repository
+}
+
+func NewService(repository Repository) Service {
+	return service{repository}
+}
+
+func (s service) CreateAPIKey(ctx context.Context, userID uint, req CreateAPIKey) (*CreateAPIKeyResponse, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "apikey.Service/CreateAPIKey")
+	defer span.End()
+
+	// Validate the request
+	if strings.TrimSpace(req.Name) == "" {
+		return nil, ErrNameRequired
+	}
+	for _, scope := range req.Scopes {
+		if !validScopes[scope] {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, ErrExpiryPassed
+	}
+
+	// Generate the key; only its hash is stored
+	prefix, rawKey, err := generateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	key := &models.APIKey{
+		UserID:    userID,
+		Name:      req.Name,
+		Prefix:    prefix,
+		HashedKey: hashKey(rawKey),
+		Scopes:    strings.Join(req.Scopes, " "),
+		ExpiresAt: req.ExpiresAt,
+	}
+	err = s.repository.CreateAPIKey(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CreateAPIKeyResponse{
+		APIKey: toAPIKey(*key),
+		Key:    rawKey,
+	}, nil
+}
+
+func (s service) GetAPIKeyList(ctx context.Context, userID uint) ([]APIKey, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "apikey.Service/GetAPIKeyList")
+	defer span.End()
+
+	keys, err := s.repository.GetAPIKeyList(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	keyList := []APIKey{}
+	for _, key := range keys {
+		keyList = append(keyList, toAPIKey(key))
+	}
+	return keyList, nil
+}
+
+func (s service) RevokeAPIKey(ctx context.Context, userID, id uint) error {
+	ctx, span := tracing.Tracer.Start(ctx, "apikey.Service/RevokeAPIKey")
+	defer span.End()
+
+	return s.repository.RevokeAPIKey(ctx, userID, id)
+}
+
+func (s service) Authenticate(ctx context.Context, rawKey string) (*models.APIKey, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "apikey.Service/Authenticate")
+	defer span.End()
+
+	// Look the key up by its public prefix
+	parts := strings.Split(rawKey, "_")
+	if len(parts) != 3 || parts[0] != keyPrefix {
+		return nil, ErrInvalidKey
+	}
+	key, err := s.repository.GetAPIKeyByPrefix(ctx, parts[1])
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+
+	// Compare the hash in constant time and check the key is still usable
+	if subtle.ConstantTimeCompare([]byte(key.HashedKey), []byte(hashKey(rawKey))) != 1 {
+		return nil, ErrInvalidKey
+	}
+	now := time.Now()
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && !now.Before(*key.ExpiresAt)) {
+		return nil, ErrInvalidKey
+	}
+
+	// Keys stop working with their owner's account, and work again if it is reactivated or restored
+	if key.User.ID == 0 || !key.User.Active || key.User.DeletedAt.Valid {
+		return nil, ErrInvalidKey
+	}
+
+	err = s.repository.TouchAPIKey(ctx, key.ID, now, lastUsedInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func generateKey() (string, string, error) {
+	prefix := make([]byte, 6)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(prefix); err != nil {
+		return "", "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	prefixHex := hex.EncodeToString(prefix)
+	return prefixHex, keyPrefix + "_" + prefixHex + "_" + hex.EncodeToString(secret), nil
+}
+
+func hashKey(rawKey string) string {
+	sum := sha256.Sum256([]byte(rawKey))
+	return hex.EncodeToString(sum[:])
+}
+
+func toAPIKey(key models.APIKey) APIKey {
+	return APIKey{
+		ID:         key.ID,
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		Scopes:     key.ScopeList(),
+		ExpiresAt:  formatTime(key.ExpiresAt),
+		LastUsedAt: formatTime(key.LastUsedAt),
+		RevokedAt:  formatTime(key.RevokedAt),
+		CreatedAt:  key.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+}
+
+func formatTime(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	formatted := t.Format("2006-01-02 15:04:05")
+	return &formatted
+}
@@ -0,0 +1,38 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+
+	"go.learning/models"
+	"gorm.io/gorm"
+)
+
+type Repository interface {
+	CreateEvent(ctx context.Context, event *models.AuditEvent) error
+	CreateRequestLog(ctx context.Context, entry *models.Logger) error
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) *repository {
+	return &repository{db}
+}
+
+func (r *repository) CreateEvent(ctx context.Context, event *models.AuditEvent) error {
+	err := r.db.WithContext(ctx).Create(event).Error
+	if err != nil {
+		return fmt.Errorf("failed to create audit event: %w", err)
+	}
+	return nil
+}
+
+func (r *repository) CreateRequestLog(ctx context.Context, entry *models.Logger) error {
+	err := r.db.WithContext(ctx).Create(entry).Error
+	if err != nil {
+		return fmt.Errorf("failed to create request log: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package audit
+
+import (
+	"context"
+	"time"
+
+	"github.com/labstack/gommon/log"
+	"go.learning/models"
+	"go.learning/tracing"
+)
+
+// writeTimeout bounds a write that outlives the request it belongs to
+const writeTimeout = 5 * time.Second
+
+type Service interface {
+	Record(ctx context.Context, userID uint, action string)
+	LogRequest(ctx context.Context, entry *models.Logger) error
+}
+
+type service struct {
+	repository Repository
+}
+
+func NewService(repository Repository) Service {
+	return service{repository}
+}
+
+// Record stores an audit event. The audited change already happened, so a failure is logged rather than returned.
+func (s service) Record(ctx context.Context, userID uint, action string) {
+	ctx, span := tracing.Tracer.Start(ctx, "audit.Service/Record")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), writeTimeout)
+	defer cancel()
+
+	err := s.repository.CreateEvent(ctx, &models.AuditEvent{UserID: userID, Action: action})
+	if err != nil {
+		log.Errorf("failed to record %s for user %d: %v", action, userID, err)
+	}
+}
+
+// LogRequest stores a request log entry, even when the request's context was cancelled
+func (s service) LogRequest(ctx context.Context, entry *models.Logger) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), writeTimeout)
+	defer cancel()
+
+	return s.repository.CreateRequestLog(ctx, entry)
+}
@@ -1,15 +1,22 @@
 package auth
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
+	"go.learning/api/user"
+	"go.learning/password"
 )
 
 type Handler interface {
 	Login(c echo.Context) (err error)
 	RefreshToken(c echo.Context) (err error)
 	Logout(c echo.Context) (err error)
+	RequestMagicLink(c echo.Context) (err error)
+	VerifyMagicLink(c echo.Context) (err error)
+	ForgotPassword(c echo.Context) (err error)
+	ResetPassword(c echo.Context) (err error)
 }
 type handler struct {
 	service Service
@@ -25,7 +32,10 @@ func (h handler) Login(c echo.Context) (err error) {
 		return
 	}
 
-	loginResponse, err := h.service.Login(req.Email, req.Password)
+	loginResponse, err := h.service.Login(c.Request().Context(), req.Email, req.Password)
+	if errors.Is(err, ErrUserInactive) {
+		return c.JSON(http.StatusForbidden, err.Error())
+	}
 	if err != nil {
 		return
 	}
@@ -39,7 +49,13 @@ func (h handler) RefreshToken(c echo.Context) (err error) {
 		return
 	}
 
-	refreshTokenResponse, err := h.service.RefreshToken(req.RefreshToken)
+	refreshTokenResponse, err := h.service.RefreshToken(c.Request().Context(), req.RefreshToken)
+	if errors.Is(err, ErrInvalidRefreshToken) {
+		return c.JSON(http.StatusUnauthorized, err.Error())
+	}
+	if errors.Is(err, ErrUserInactive) {
+		return c.JSON(http.StatusForbidden, err.Error())
+	}
 	if err != nil {
 		return
 	}
@@ -53,10 +69,81 @@ func (h handler) Logout(c echo.Context) (err error) {
 		return
 	}
 
-	logoutResponse, err := h.service.Logout(req.RefreshToken)
+	logoutResponse, err := h.service.Logout(c.Request().Context(), req.RefreshToken)
 	if err != nil {
 		return
 	}
 
 	return c.JSON(http.StatusNoContent, logoutResponse)
 }
+
+func (h handler) RequestMagicLink(c echo.Context) (err error) {
+	var req MagicLink
+	if err = c.Bind(&req); err != nil {
+		return
+	}
+
+	err = h.service.SendMagicLink(c.Request().Context(), req.Email)
+	if err != nil {
+		return
+	}
+
+	// Same response whether or not the email belongs to a user
+	return c.JSON(http.StatusAccepted, "If the email is registered, a sign-in link has been sent")
+}
+
+// VerifyMagicLink is a POST so mail scanners that prefetch links can't consume the token
+func (h handler) VerifyMagicLink(c echo.Context) (err error) {
+	var req VerifyMagicLink
+	if err = c.Bind(&req); err != nil {
+		return
+	}
+
+	loginResponse, err := h.service.LoginWithMagicLink(c.Request().Context(), req.Token)
+	if errors.Is(err, ErrInvalidMagicLink) {
+		return c.JSON(http.StatusUnauthorized, err.Error())
+	}
+	if errors.Is(err, ErrUserInactive) {
+		return c.JSON(http.StatusForbidden, err.Error())
+	}
+	if err != nil {
+		return
+	}
+
+	return c.JSON(http.StatusOK, loginResponse)
+}
+
+func (h handler) ForgotPassword(c echo.Context) (err error) {
+	var req ForgotPassword
+	if err = c.Bind(&req); err != nil {
+		return
+	}
+
+	err = h.service.SendPasswordReset(c.Request().Context(), req.Email)
+	if err != nil {
+		return
+	}
+
+	// Same response whether or not the email belongs to a user
+	return c.JSON(http.StatusAccepted, "If the email is registered, a password reset link has been sent")
+}
+
+func (h handler) ResetPassword(c echo.Context) (err error) {
+	var req ResetPassword
+	if err = c.Bind(&req); err != nil {
+		return
+	}
+
+	err = h.service.ResetPassword(c.Request().Context(), req.Token, req.NewPassword)
+	var weakErr *password.WeakPasswordError
+	switch {
+	case errors.Is(err, ErrInvalidResetToken), errors.Is(err, user.ErrPasswordReused):
+		return c.JSON(http.StatusBadRequest, err.Error())
+	case errors.As(err, &weakErr):
+		return c.JSON(http.StatusBadRequest, weakErr)
+	case err != nil:
+		return
+	}
+
+	return c.JSON(http.StatusNoContent, nil)
+}
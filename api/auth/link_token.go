@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+const linkTokenKeyPrefix = "link:"
+
+// linkTokenType marks link tokens so no session endpoint mistakes them for access or refresh tokens
+const linkTokenType = "link"
+
+// errInvalidLinkToken is mapped by each caller to the error of its own flow
+var errInvalidLinkToken = errors.New("invalid link token")
+
+// linkSigningKey derives the key link tokens are signed with from the JWT secret, so a link token
+// never verifies as a session token, and the other way round
+func linkSigningKey(secretKey string) []byte {
+	mac := hmac.New(sha256.New, []byte(secretKey))
+	mac.Write([]byte("link-token"))
+	return mac.Sum(nil)
+}
+
+// issueLinkToken signs a short-lived token for an emailed link. The purpose keeps a token from being
+// accepted by any other flow, and the ID stored in Redis makes it single use.
+func (s *service) issueLinkToken(ctx context.Context, purpose string, userID uint, ttl time.Duration) (string, error) {
+	linkID := uuid.New().String()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"userID":    strconv.FormatUint(uint64(userID), 10),
+		"tokenType": linkTokenType,
+		"purpose":   purpose,
+		"jti":       linkID,
+		"exp":       time.Now().Add(ttl).Unix(),
+	}).SignedString(linkSigningKey(s.config.Current().JWT.SecretKey))
+	if err != nil {
+		return "", err
+	}
+
+	err = s.redisClient.Set(ctx, linkTokenKeyPrefix+purpose+":"+linkID, userID, ttl).Err()
+	if err != nil {
+		return "", fmt.Errorf("failed to store link token: %w", err)
+	}
+	return token, nil
+}
+
+// parseLinkToken verifies a link token's signature, expiry and purpose without using it up.
+// It returns the user the token was issued to and the link ID that makes it single use.
+func (s *service) parseLinkToken(purpose, token string) (uint, string, error) {
+	parsed, err := jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return linkSigningKey(s.config.Current().JWT.SecretKey), nil
+	})
+	if err != nil || !parsed.Valid {
+		return 0, "", errInvalidLinkToken
+	}
+	claims, ok := parsed.Claims.(jwt.MapClaims)
+	if !ok || claims["tokenType"] != linkTokenType || claims["purpose"] != purpose {
+		return 0, "", errInvalidLinkToken
+	}
+	userID, err := strconv.ParseUint(fmt.Sprintf("%s", claims["userID"]), 10, 0)
+	if err != nil {
+		return 0, "", errInvalidLinkToken
+	}
+	return uint(userID), fmt.Sprintf("%s", claims["jti"]), nil
+}
+
+// consumeLinkToken verifies a link token and invalidates it, returning the user it was issued to
+func (s *service) consumeLinkToken(ctx context.Context, purpose, token string) (uint, error) {
+	userID, linkID, err := s.parseLinkToken(purpose, token)
+	if err != nil {
+		return 0, err
+	}
+
+	storedUserID, err := s.redisClient.GetDel(ctx, linkTokenKeyPrefix+purpose+":"+linkID).Uint64()
+	if err == redis.Nil {
+		return 0, errInvalidLinkToken
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to load link token: %w", err)
+	}
+	if storedUserID != uint64(userID) {
+		return 0, errInvalidLinkToken
+	}
+	return userID, nil
+}
+
+// linkURL appends the token to the page configured to receive it
+func linkURL(base, token string) (string, error) {
+	link, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("failed to build link: %w", err)
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	return link.String(), nil
+}
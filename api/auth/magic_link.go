@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.learning/api/user"
+	"go.learning/mailer"
+	"go.learning/metrics"
+	"go.learning/tracing"
+)
+
+const magicLinkPurpose = "magic-link"
+
+var ErrInvalidMagicLink = errors.New("magic link is invalid, expired or already used")
+
+// SendMagicLink emails a single-use login link. Unknown emails are ignored so the endpoint can't be used to find accounts.
+func (s *service) SendMagicLink(ctx context.Context, email string) error {
+	ctx, span := tracing.Tracer.Start(ctx, "auth.Service/SendMagicLink")
+	defer span.End()
+
+	cfg := s.config.Current().MagicLink
+
+	u, err := s.repository.GetUserByEmail(ctx, email)
+	if errors.Is(err, user.ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, err := s.issueLinkToken(ctx, magicLinkPurpose, u.ID, cfg.TTL)
+	if err != nil {
+		return err
+	}
+	link, err := linkURL(cfg.URL, token)
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(ctx, mailer.Message{
+		To:      u.Email,
+		Subject: "Your sign-in link",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the link below to sign in. It can be used once and expires in %s.\n\n%s\n\nIf you didn't ask for this email you can ignore it.\n",
+			u.FirstName, cfg.TTL, link),
+	})
+}
+
+// LoginWithMagicLink consumes a magic link token and creates a session exactly like Login
+func (s *service) LoginWithMagicLink(ctx context.Context, token string) (response LoginResponse, err error) {
+	ctx, span := tracing.Tracer.Start(ctx, "auth.Service/LoginWithMagicLink")
+	defer span.End()
+
+	// Record the outcome of the login attempt
+	defer func() {
+		if err != nil {
+			metrics.LoginAttemptsTotal.WithLabelValues(metrics.LoginFailure).Inc()
+			return
+		}
+		metrics.LoginAttemptsTotal.WithLabelValues(metrics.LoginSuccess).Inc()
+	}()
+
+	userID, err := s.consumeLinkToken(ctx, magicLinkPurpose, token)
+	if errors.Is(err, errInvalidLinkToken) {
+		return LoginResponse{}, ErrInvalidMagicLink
+	}
+	if err != nil {
+		return LoginResponse{}, err
+	}
+
+	// The user may have been deleted since the link was sent
+	u, err := s.repository.GetUserByID(ctx, userID)
+	if errors.Is(err, user.ErrUserNotFound) {
+		return LoginResponse{}, ErrInvalidMagicLink
+	}
+	if err != nil {
+		return LoginResponse{}, err
+	}
+
+	return s.CreateSession(ctx, u.ID)
+}
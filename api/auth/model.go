@@ -1,14 +1,37 @@
 package auth
 
+// ClientSubjectPrefix marks token subjects that are OAuth clients rather than users
+const ClientSubjectPrefix = "client:"
+
 type Login struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 }
 
+type MagicLink struct {
+	Email string `json:"email"`
+}
+
+type VerifyMagicLink struct {
+	Token string `json:"token"`
+}
+
+type ForgotPassword struct {
+	Email string `json:"email"`
+}
+
+type ResetPassword struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
 type LoginResponse struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
 	ExpiresAt    int64  `json:"expires_at"`
+
+	// PasswordChangeRequired means AccessToken only works on the change-password endpoint and there is no refresh token
+	PasswordChangeRequired bool `json:"password_change_required,omitempty"`
 }
 
 type RefreshToken struct {
@@ -16,8 +39,9 @@ type RefreshToken struct {
 }
 
 type RefreshTokenResponse struct {
-	AccessToken string `json:"access_token"`
-	ExpiresAt   int64  `json:"expires_at"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token,omitempty"` // set when sliding sessions are enabled
+	ExpiresAt    int64  `json:"expires_at"`
 }
 
 type Logout struct {
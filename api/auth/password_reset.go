@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.learning/api/user"
+	"go.learning/mailer"
+	"go.learning/models"
+	"go.learning/tracing"
+)
+
+const passwordResetPurpose = "password-reset"
+
+var ErrInvalidResetToken = errors.New("password reset link is invalid, expired or already used")
+
+// SendPasswordReset emails a single-use reset link. Unknown emails are ignored so the endpoint can't be used to find accounts.
+func (s *service) SendPasswordReset(ctx context.Context, email string) error {
+	ctx, span := tracing.Tracer.Start(ctx, "auth.Service/SendPasswordReset")
+	defer span.End()
+
+	cfg := s.config.Current().PasswordReset
+
+	u, err := s.repository.GetUserByEmail(ctx, email)
+	if errors.Is(err, user.ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, err := s.issueLinkToken(ctx, passwordResetPurpose, u.ID, cfg.TTL)
+	if err != nil {
+		return err
+	}
+	link, err := linkURL(cfg.URL, token)
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(ctx, mailer.Message{
+		To:      u.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the link below to choose a new password. It can be used once and expires in %s.\n\n%s\n\nIf you didn't ask for this email you can ignore it.\n",
+			u.FirstName, cfg.TTL, link),
+	})
+}
+
+// ResetPassword consumes a reset token, sets a new password that passes the password policy and ends the user's sessions
+func (s *service) ResetPassword(ctx context.Context, token, newPassword string) error {
+	ctx, span := tracing.Tracer.Start(ctx, "auth.Service/ResetPassword")
+	defer span.End()
+
+	// Check the new password before using up the link so a rejected password can be retried
+	userID, _, err := s.parseLinkToken(passwordResetPurpose, token)
+	if errors.Is(err, errInvalidLinkToken) {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+
+	u, err := s.repository.GetUserByID(ctx, userID)
+	if errors.Is(err, user.ErrUserNotFound) {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+
+	err = s.policy.Check(ctx, newPassword, u.Email, u.FirstName, u.LastName)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.consumeLinkToken(ctx, passwordResetPurpose, token)
+	if errors.Is(err, errInvalidLinkToken) {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+
+	err = user.SetPassword(ctx, s.repository, s.hasher, u, newPassword, s.config.Current().Password.Policy.HistorySize)
+	if err != nil {
+		return err
+	}
+
+	s.audit.Record(ctx, u.ID, models.AuditPasswordReset)
+
+	// Whoever knew the old password must not stay logged in
+	return s.RevokeUserSessions(ctx, u.ID)
+}
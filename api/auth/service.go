@@ -1,63 +1,211 @@
 package auth
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
+	"github.com/labstack/gommon/log"
 	"go.learning/api/user"
 	"go.learning/config"
+	"go.learning/mailer"
+	"go.learning/metrics"
+	"go.learning/models"
+	"go.learning/password"
+	"go.learning/tracing"
 	"go.learning/utils"
 )
 
+var (
+	ErrUserInactive        = errors.New("user account is deactivated")
+	ErrInvalidRefreshToken = errors.New("not a refresh token of a user session")
+)
+
+// passwordChangeTokenTTL is how long a user with an expired password has to choose a new one
+const passwordChangeTokenTTL = 10 * time.Minute
+
 type Service interface {
-	Login(email, password string) (LoginResponse, error)
-	RefreshToken(refreshToken string) (RefreshTokenResponse, error)
-	Logout(refreshToken string) (LogoutResponse, error)
+	Login(ctx context.Context, email, password string) (LoginResponse, error)
+	RefreshToken(ctx context.Context, refreshToken string) (RefreshTokenResponse, error)
+	RefreshClientToken(ctx context.Context, refreshToken string) (RefreshTokenResponse, error)
+	Logout(ctx context.Context, refreshToken string) (LogoutResponse, error)
+	CreateSession(ctx context.Context, userID uint) (LoginResponse, error)
+	CreateClientSession(ctx context.Context, clientID string) (LoginResponse, error)
+	RevokeSession(ctx context.Context, token string) error
+	RevokeUserSessions(ctx context.Context, userID uint) error
+	SendMagicLink(ctx context.Context, email string) error
+	LoginWithMagicLink(ctx context.Context, token string) (LoginResponse, error)
+	SendPasswordReset(ctx context.Context, email string) error
+	ResetPassword(ctx context.Context, token, newPassword string) error
 }
 type service struct {
 	repository  user.Repository
 	redisClient *redis.Client
-	cfg         config.Config
+	config      *config.Watcher
+	mailer      mailer.Mailer
+	hasher      password.Hasher
+	policy      password.Policy
+	audit       user.AuditRecorder
 }
 
-func NewService(userRepo user.Repository, redisClient *redis.Client, cfg config.Config) Service {
+func NewService(userRepo user.Repository, redisClient *redis.Client, config *config.Watcher, mailer mailer.Mailer, hasher password.Hasher, policy password.Policy, audit user.AuditRecorder) Service {
 	return &service{
 		repository:  userRepo,
 		redisClient: redisClient,
-		cfg:         cfg,
+		config:      config,
+		mailer:      mailer,
+		hasher:      hasher,
+		policy:      policy,
+		audit:       audit,
 	}
 }
 
-func (s *service) Login(email string, password string) (LoginResponse, error) {
+func (s *service) Login(ctx context.Context, email string, password string) (response LoginResponse, err error) {
+	ctx, span := tracing.Tracer.Start(ctx, "auth.Service/Login")
+	defer span.End()
+
+	// Record the outcome of the login attempt
+	defer func() {
+		if err != nil {
+			metrics.LoginAttemptsTotal.WithLabelValues(metrics.LoginFailure).Inc()
+			return
+		}
+		metrics.LoginAttemptsTotal.WithLabelValues(metrics.LoginSuccess).Inc()
+	}()
+
 	// Check if the user exists
-	user, err := s.repository.GetUserByEmail(email)
+	user, err := s.repository.GetUserByEmail(ctx, email)
 	if err != nil {
 		return LoginResponse{}, err
 	}
 
 	// Check if the password is correct
-	if !utils.ValidatePassword(password, user.HashedPassword) {
+	ok, needsRehash, err := s.hasher.Verify(password, user.HashedPassword)
+	if err != nil {
+		return LoginResponse{}, err
+	}
+	if !ok {
+		s.audit.Record(ctx, user.ID, models.AuditLoginFailed)
 		return LoginResponse{}, fmt.Errorf("invalid password")
 	}
 
-	// Convert user.ID to string
-	userID := fmt.Sprintf("%d", user.ID)
+	// Upgrade hashes from older algorithms or parameters while the plain password is at hand
+	if needsRehash {
+		s.rehash(ctx, user.ID, password)
+	}
+
+	if !user.Active {
+		return LoginResponse{}, ErrUserInactive
+	}
+
+	// An expired password can only be used to choose a new one
+	if passwordExpired(s.config.Current().Password.Policy.MaxAge, user.PasswordChangedAt, user.CreatedAt) {
+		return s.createPasswordChangeSession(ctx, user.ID)
+	}
+
+	return s.CreateSession(ctx, user.ID)
+}
+
+// rehash replaces the stored hash; failures are logged because the login itself succeeded
+func (s *service) rehash(ctx context.Context, userID uint, password string) {
+	hashedPassword, err := s.hasher.Hash(password)
+	if err == nil {
+		err = s.repository.UpdateUserPassword(ctx, userID, hashedPassword)
+	}
+	if err != nil {
+		log.Warnf("failed to rehash password for user %d: %v", userID, err)
+	}
+}
+
+// passwordExpired reports whether a password is older than maxAge; passwords set before tracking began date from account creation
+func passwordExpired(maxAge time.Duration, changedAt *time.Time, createdAt time.Time) bool {
+	if maxAge <= 0 {
+		return false
+	}
+	setAt := createdAt
+	if changedAt != nil {
+		setAt = *changedAt
+	}
+	return time.Since(setAt) > maxAge
+}
+
+// createPasswordChangeSession issues a short-lived token that only works on the change-password endpoint
+func (s *service) createPasswordChangeSession(ctx context.Context, userID uint) (LoginResponse, error) {
+	jwtConfig := s.config.Current().JWT
 	sessionId := uuid.New().String()
+	expiresAt := time.Now().Add(passwordChangeTokenTTL)
+
+	accessToken, err := utils.GenerateRestrictedJWT(jwtConfig.SecretKey, sessionId, fmt.Sprintf("%d", userID), utils.ScopePasswordChange, expiresAt)
+	if err != nil {
+		return LoginResponse{}, err
+	}
+
+	err = utils.StoreTokenInRedis(ctx, s.redisClient, sessionId, accessToken, expiresAt)
+	if err != nil {
+		return LoginResponse{}, err
+	}
+	err = utils.TrackSession(ctx, s.redisClient, fmt.Sprintf("%d", userID), sessionId, expiresAt)
+	if err != nil {
+		return LoginResponse{}, err
+	}
+
+	return LoginResponse{
+		AccessToken:            accessToken,
+		ExpiresAt:              expiresAt.Unix(),
+		PasswordChangeRequired: true,
+	}, nil
+}
+
+// CreateSession issues tokens for a user. Deactivated users can't start sessions, whichever way they log in.
+func (s *service) CreateSession(ctx context.Context, userID uint) (LoginResponse, error) {
+	user, err := s.repository.GetUserByID(ctx, userID)
+	if err != nil {
+		return LoginResponse{}, err
+	}
+	if !user.Active {
+		return LoginResponse{}, ErrUserInactive
+	}
+
+	response, err := s.createSession(ctx, fmt.Sprintf("%d", userID))
+	if err != nil {
+		return LoginResponse{}, err
+	}
+	s.audit.Record(ctx, userID, models.AuditSessionCreated)
+	return response, nil
+}
+
+// CreateClientSession issues tokens for an OAuth client acting on its own behalf.
+// The subject is not a user ID, so these tokens never resolve to a user.
+func (s *service) CreateClientSession(ctx context.Context, clientID string) (LoginResponse, error) {
+	return s.createSession(ctx, ClientSubjectPrefix+clientID)
+}
+
+func (s *service) createSession(ctx context.Context, subject string) (LoginResponse, error) {
+	jwtConfig := s.config.Current().JWT
+	sessionId := uuid.New().String()
+
+	// Compute every expiry from a single timestamp
+	now := time.Now()
+	accessExpiresAt, refreshExpiresAt := tokenExpiry(jwtConfig, now, now)
 
 	// Generate access and refresh tokens
-	accessToken, refreshToken, expiresAt, err := utils.GenerateJWT(s.cfg.JWT.SecretKey, sessionId, userID)
+	accessToken, refreshToken, err := utils.GenerateJWT(jwtConfig.SecretKey, sessionId, subject, now, accessExpiresAt, refreshExpiresAt)
 	if err != nil {
 		return LoginResponse{}, err
 	}
 
-	// Session ID is the user ID
+	// Store the access token in Redis until it expires
+	err = utils.StoreTokenInRedis(ctx, s.redisClient, sessionId, accessToken, accessExpiresAt)
 	if err != nil {
 		return LoginResponse{}, err
 	}
 
-	// Store the refresh token in Redis
-	err = utils.StoreTokenInRedis(s.redisClient, sessionId, accessToken)
+	// Index the session under its subject so all of them can be revoked at once
+	err = utils.TrackSession(ctx, s.redisClient, subject, sessionId, refreshExpiresAt)
 	if err != nil {
 		return LoginResponse{}, err
 	}
@@ -65,53 +213,176 @@ func (s *service) Login(email string, password string) (LoginResponse, error) {
 	return LoginResponse{
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
-		ExpiresAt:    expiresAt,
+		ExpiresAt:    accessExpiresAt.Unix(),
 	}, nil
 }
 
-func (s *service) RefreshToken(refreshToken string) (RefreshTokenResponse, error) {
+// tokenExpiry returns the access and refresh token expiries, capped by the absolute session lifetime
+func tokenExpiry(jwtConfig config.JWT, now, sessionStartedAt time.Time) (time.Time, time.Time) {
+	accessExpiresAt := now.Add(jwtConfig.AccessTokenTTL)
+	refreshExpiresAt := now.Add(jwtConfig.RefreshTokenTTL)
+	if jwtConfig.MaxSessionLifetime > 0 {
+		sessionEndsAt := sessionStartedAt.Add(jwtConfig.MaxSessionLifetime)
+		if accessExpiresAt.After(sessionEndsAt) {
+			accessExpiresAt = sessionEndsAt
+		}
+		if refreshExpiresAt.After(sessionEndsAt) {
+			refreshExpiresAt = sessionEndsAt
+		}
+	}
+	return accessExpiresAt, refreshExpiresAt
+}
+
+func (s *service) RefreshToken(ctx context.Context, refreshToken string) (RefreshTokenResponse, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "auth.Service/RefreshToken")
+	defer span.End()
+
+	return s.refresh(ctx, refreshToken, false)
+}
+
+// RefreshClientToken refreshes a session issued to an OAuth client. The caller checks the grant belongs to the client.
+func (s *service) RefreshClientToken(ctx context.Context, refreshToken string) (RefreshTokenResponse, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "auth.Service/RefreshClientToken")
+	defer span.End()
+
+	return s.refresh(ctx, refreshToken, true)
+}
+
+// refresh exchanges a refresh token for new tokens. Sessions issued to OAuth clients are only refreshed for
+// the client, so their grant, and the scopes it limits them to, can't be outlived.
+func (s *service) refresh(ctx context.Context, refreshToken string, client bool) (RefreshTokenResponse, error) {
+	jwtConfig := s.config.Current().JWT
+
 	// Validate the refresh token
-	claims, err := utils.ValidateJWT(s.cfg.JWT.SecretKey, refreshToken)
+	claims, err := utils.ValidateJWT(jwtConfig.SecretKey, refreshToken)
 	if err != nil {
 		return RefreshTokenResponse{}, err
 	}
 
+	// Access tokens, restricted tokens and tokens of OAuth clients can't be exchanged for new ones
+	if utils.TokenType(*claims) != utils.TokenTypeRefresh || (*claims)["scope"] != nil {
+		return RefreshTokenResponse{}, ErrInvalidRefreshToken
+	}
+
 	// Get the user ID and session ID from the claims
 	userIDStr := fmt.Sprintf("%s", (*claims)["userID"])
 	sessionId := fmt.Sprintf("%s", (*claims)["sessionId"])
+	userID, err := strconv.ParseUint(userIDStr, 10, 0)
+	if err != nil {
+		return RefreshTokenResponse{}, ErrInvalidRefreshToken
+	}
+
+	// Reject refresh tokens of sessions that were logged out or revoked
+	revoked, err := utils.IsSessionRevoked(ctx, s.redisClient, sessionId)
+	if err != nil {
+		return RefreshTokenResponse{}, err
+	}
+	if revoked {
+		return RefreshTokenResponse{}, fmt.Errorf("session revoked")
+	}
+
+	grant, err := utils.GetOAuthGrant(ctx, s.redisClient, sessionId)
+	if err != nil {
+		return RefreshTokenResponse{}, err
+	}
+	if (grant != nil) != client {
+		return RefreshTokenResponse{}, ErrInvalidRefreshToken
+	}
+
+	// Deleted and deactivated users can't keep their sessions alive
+	u, err := s.repository.GetUserByID(ctx, uint(userID))
+	if errors.Is(err, user.ErrUserNotFound) {
+		return RefreshTokenResponse{}, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return RefreshTokenResponse{}, err
+	}
+	if !u.Active {
+		return RefreshTokenResponse{}, ErrUserInactive
+	}
+
+	// Enforce the absolute session lifetime
+	now := time.Now()
+	sessionStartedAt := utils.SessionStartedAt(*claims, now)
+	if jwtConfig.MaxSessionLifetime > 0 && !now.Before(sessionStartedAt.Add(jwtConfig.MaxSessionLifetime)) {
+		return RefreshTokenResponse{}, fmt.Errorf("session expired")
+	}
 
 	// Generate new access and refresh tokens
-	accessToken, _, expiresAt, err := utils.GenerateJWT(s.cfg.JWT.SecretKey, sessionId, userIDStr)
+	accessExpiresAt, refreshExpiresAt := tokenExpiry(jwtConfig, now, sessionStartedAt)
+	accessToken, newRefreshToken, err := utils.GenerateJWT(jwtConfig.SecretKey, sessionId, userIDStr, sessionStartedAt, accessExpiresAt, refreshExpiresAt)
 	if err != nil {
 		return RefreshTokenResponse{}, err
 	}
 
-	// Store the new refresh token in Redis
-	err = utils.StoreTokenInRedis(s.redisClient, sessionId, accessToken)
+	// Store the new access token in Redis until it expires
+	err = utils.StoreTokenInRedis(ctx, s.redisClient, sessionId, accessToken, accessExpiresAt)
+	if err != nil {
+		return RefreshTokenResponse{}, err
+	}
+	err = utils.TrackSession(ctx, s.redisClient, userIDStr, sessionId, refreshExpiresAt)
 	if err != nil {
 		return RefreshTokenResponse{}, err
 	}
 
-	return RefreshTokenResponse{
+	response := RefreshTokenResponse{
 		AccessToken: accessToken,
-		ExpiresAt:   expiresAt,
-	}, nil
+		ExpiresAt:   accessExpiresAt.Unix(),
+	}
+
+	// Sliding sessions also extend the refresh token
+	if jwtConfig.SlidingSession {
+		response.RefreshToken = newRefreshToken
+	}
+
+	return response, nil
 }
 
-func (s *service) Logout(refreshToken string) (LogoutResponse, error) {
-	// Validate the refresh token
-	claims, err := utils.ValidateJWT(s.cfg.JWT.SecretKey, refreshToken)
+func (s *service) Logout(ctx context.Context, refreshToken string) (LogoutResponse, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "auth.Service/Logout")
+	defer span.End()
+
+	// Revoke the session behind the refresh token
+	err := s.RevokeSession(ctx, refreshToken)
 	if err != nil {
 		return LogoutResponse{}, err
 	}
 
+	return LogoutResponse{
+		Success: true,
+	}, nil
+}
+
+// RevokeSession ends the session behind an access or refresh token
+func (s *service) RevokeSession(ctx context.Context, token string) error {
+	jwtConfig := s.config.Current().JWT
+
+	// Validate the token
+	claims, err := utils.ValidateJWT(jwtConfig.SecretKey, token)
+	if err != nil {
+		return err
+	}
+
 	// Get the session ID from the claims
 	sessionId := fmt.Sprintf("%s", (*claims)["sessionId"])
 
-	// Delete the session ID from Redis
-	err = utils.DeleteTokenInRedis(s.redisClient, sessionId)
+	// Reject the session's refresh tokens until the last one expires. They were issued with the refresh TTL
+	// of their time, which may have been shortened since, so go by their expiry instead of the config.
+	until := utils.ExpiresAt(*claims)
+	latest, err := utils.GetSessionExpiry(ctx, s.redisClient, fmt.Sprintf("%s", (*claims)["userID"]), sessionId)
+	if err != nil {
+		return err
+	}
+	if latest.After(until) {
+		until = latest
+	}
+	return utils.RevokeSessionInRedis(ctx, s.redisClient, sessionId, until)
+}
+
+// RevokeUserSessions ends every session of the user, e.g. after the account is deleted
+func (s *service) RevokeUserSessions(ctx context.Context, userID uint) error {
+	ctx, span := tracing.Tracer.Start(ctx, "auth.Service/RevokeUserSessions")
+	defer span.End()
 
-	return LogoutResponse{
-		Success: true,
-	}, nil
+	return utils.RevokeSubjectSessions(ctx, s.redisClient, fmt.Sprintf("%d", userID))
 }
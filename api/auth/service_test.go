@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"go.learning/api/user"
+	"go.learning/config"
+	"go.learning/models"
+)
+
+// fakeUserRepository knows a single active user
+type fakeUserRepository struct {
+	user.Repository
+}
+
+func (fakeUserRepository) GetUserByID(ctx context.Context, id uint) (*models.User, error) {
+	if id != 1 {
+		return nil, user.ErrUserNotFound
+	}
+	return &models.User{ID: 1, Email: "ada@example.com", Active: true}, nil
+}
+
+type fakeAuditRecorder struct{}
+
+func (fakeAuditRecorder) Record(ctx context.Context, userID uint, action string) {}
+
+// newTestService returns a service with the given refresh TTL. Services sharing a Redis server stand in for
+// one service before and after a config reload.
+func newTestService(redisClient *redis.Client, refreshTokenTTL time.Duration) Service {
+	watcher := config.NewWatcher(config.Config{JWT: config.JWT{
+		SecretKey:       "test-secret",
+		AccessTokenTTL:  time.Minute,
+		RefreshTokenTTL: refreshTokenTTL,
+	}})
+	return NewService(fakeUserRepository{}, redisClient, watcher, nil, nil, nil, fakeAuditRecorder{})
+}
+
+func TestRevokedSessionOutlivesShortenedRefreshTTL(t *testing.T) {
+	tests := []struct {
+		name    string
+		revoke  func(ctx context.Context, before, after Service, session LoginResponse) error
+		wantErr bool
+	}{
+		{
+			name:    "not revoked",
+			revoke:  func(ctx context.Context, before, after Service, session LoginResponse) error { return nil },
+			wantErr: false,
+		},
+		{
+			name: "logout after the TTL is shortened",
+			revoke: func(ctx context.Context, before, after Service, session LoginResponse) error {
+				_, err := after.Logout(ctx, session.RefreshToken)
+				return err
+			},
+			wantErr: true,
+		},
+		{
+			name: "access token revoked after the TTL is shortened",
+			revoke: func(ctx context.Context, before, after Service, session LoginResponse) error {
+				return after.RevokeSession(ctx, session.AccessToken)
+			},
+			wantErr: true,
+		},
+		{
+			name: "user sessions revoked after the TTL is shortened",
+			revoke: func(ctx context.Context, before, after Service, session LoginResponse) error {
+				return after.RevokeUserSessions(ctx, 1)
+			},
+			wantErr: true,
+		},
+		{
+			name: "logout before the TTL is shortened",
+			revoke: func(ctx context.Context, before, after Service, session LoginResponse) error {
+				_, err := before.Logout(ctx, session.RefreshToken)
+				return err
+			},
+			wantErr: true,
+		},
+		{
+			name: "user sessions revoked before the TTL is shortened",
+			revoke: func(ctx context.Context, before, after Service, session LoginResponse) error {
+				return before.RevokeUserSessions(ctx, 1)
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			redisServer := miniredis.RunT(t)
+			redisClient := redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+			t.Cleanup(func() { redisClient.Close() })
+
+			before := newTestService(redisClient, 24*time.Hour)
+			after := newTestService(redisClient, time.Minute)
+
+			session, err := before.CreateSession(ctx, 1)
+			if err != nil {
+				t.Fatalf("CreateSession: %v", err)
+			}
+			if err := tt.revoke(ctx, before, after, session); err != nil {
+				t.Fatalf("revoke: %v", err)
+			}
+
+			// Outlast the shortened TTL; the refresh token itself is valid for another day
+			redisServer.FastForward(2 * time.Minute)
+
+			_, err = after.RefreshToken(ctx, session.RefreshToken)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("RefreshToken error = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package export
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.learning/api/user"
+	"go.learning/middlewares"
+)
+
+// retryAfterSeconds is how long clients are asked to wait before polling a pending export
+const retryAfterSeconds = "5"
+
+type Handler interface {
+	ExportMe(c echo.Context) (err error)
+	ExportUser(c echo.Context) (err error)
+}
+
+type handler struct {
+	service Service
+}
+
+func NewHandler(service Service) Handler {
+	return handler{service}
+}
+
+// ExportMe downloads the caller's own data
+func (h handler) ExportMe(c echo.Context) (err error) {
+	userID, ok := middlewares.CurrentUserID(c)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, "User is not authenticated")
+	}
+	return h.export(c, userID)
+}
+
+// ExportUser downloads any user's data on their behalf
+func (h handler) ExportUser(c echo.Context) (err error) {
+	id := c.Param("id")
+	if id == "" {
+		return c.JSON(http.StatusBadRequest, "ID is required")
+	}
+
+	// Convert id to uint
+	var userID uint
+	if _, err := fmt.Sscanf(id, "%d", &userID); err != nil {
+		return c.JSON(http.StatusBadRequest, "Invalid ID format")
+	}
+	return h.export(c, userID)
+}
+
+// export answers with the archive once it is ready and with 202 while it is being generated
+func (h handler) export(c echo.Context, userID uint) error {
+	result, err := h.service.Export(c.Request().Context(), userID)
+	if errors.Is(err, user.ErrUserNotFound) {
+		return c.JSON(http.StatusNotFound, "User not found")
+	}
+	if err != nil {
+		return err
+	}
+
+	if !result.Ready {
+		c.Response().Header().Set("Retry-After", retryAfterSeconds)
+		return c.JSON(http.StatusAccepted, Pending{Status: "pending"})
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="user-%d-export.json"`, userID))
+	return c.Blob(http.StatusOK, echo.MIMEApplicationJSONCharsetUTF8, result.Archive)
+}
@@ -0,0 +1,37 @@
+package export
+
+import (
+	"time"
+
+	"go.learning/models"
+)
+
+// Archive is everything stored about a user, as handed out for a data subject access request
+type Archive struct {
+	GeneratedAt     time.Time                   `json:"generated_at"`
+	Profile         models.User                 `json:"profile"`
+	Sessions        []Session                   `json:"sessions"`
+	Identities      []models.UserIdentity       `json:"identities"`
+	Passkeys        []models.WebAuthnCredential `json:"passkeys"`
+	APIKeys         []models.APIKey             `json:"api_keys"`
+	OAuthClients    []models.OAuthClient        `json:"oauth_clients"`
+	PasswordChanges []time.Time                 `json:"password_changes"` // when earlier passwords were replaced; hashes are never exported
+	AuditEvents     []models.AuditEvent         `json:"audit_events"`
+	RequestLogs     []models.Logger             `json:"request_logs"` // requests made while authenticated as the user
+}
+
+// Session is a login that can still be refreshed
+type Session struct {
+	ID        string    `json:"id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Result is either a finished archive or a note that it is still being generated
+type Result struct {
+	Ready   bool
+	Archive []byte // JSON encoded Archive
+}
+
+type Pending struct {
+	Status string `json:"status"`
+}
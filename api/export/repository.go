@@ -0,0 +1,67 @@
+package export
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"go.learning/api/user"
+	"go.learning/models"
+	"gorm.io/gorm"
+)
+
+type Repository interface {
+	GetArchive(ctx context.Context, userID uint) (*Archive, error)
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) *repository {
+	return &repository{db}
+}
+
+// GetArchive reads every table holding the user's data from a single snapshot
+func (r *repository) GetArchive(ctx context.Context, userID uint) (*Archive, error) {
+	var archive Archive
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("deleted_at IS NULL").First(&archive.Profile, userID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return user.ErrUserNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get user: %w", err)
+		}
+
+		if err := tx.Where("user_id = ?", userID).Order("id ASC").Find(&archive.Identities).Error; err != nil {
+			return fmt.Errorf("failed to get identities: %w", err)
+		}
+		if err := tx.Where("user_id = ?", userID).Order("id ASC").Find(&archive.Passkeys).Error; err != nil {
+			return fmt.Errorf("failed to get passkeys: %w", err)
+		}
+		if err := tx.Where("user_id = ?", userID).Order("id ASC").Find(&archive.APIKeys).Error; err != nil {
+			return fmt.Errorf("failed to get API keys: %w", err)
+		}
+		if err := tx.Where("user_id = ?", userID).Order("id ASC").Find(&archive.OAuthClients).Error; err != nil {
+			return fmt.Errorf("failed to get OAuth clients: %w", err)
+		}
+		err = tx.Model(&models.PasswordHistory{}).Where("user_id = ?", userID).Order("id ASC").
+			Pluck("created_at", &archive.PasswordChanges).Error
+		if err != nil {
+			return fmt.Errorf("failed to get password history: %w", err)
+		}
+		if err := tx.Where("user_id = ?", userID).Order("id ASC").Find(&archive.AuditEvents).Error; err != nil {
+			return fmt.Errorf("failed to get audit events: %w", err)
+		}
+		if err := tx.Where("user_id = ?", userID).Order("id ASC").Find(&archive.RequestLogs).Error; err != nil {
+			return fmt.Errorf("failed to get request logs: %w", err)
+		}
+		return nil
+	}, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	return &archive, nil
+}
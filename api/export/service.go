@@ -0,0 +1,143 @@
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/gommon/log"
+	"go.learning/config"
+	"go.learning/tracing"
+	"go.learning/utils"
+)
+
+const (
+	archiveKeyPrefix = "export:archive:"
+	pendingKeyPrefix = "export:pending:"
+
+	// generationTimeout bounds a background export; a crashed export can be retried after it
+	generationTimeout = 5 * time.Minute
+)
+
+type Service interface {
+	Export(ctx context.Context, userID uint) (Result, error)
+	DeleteArchive(ctx context.Context, userID uint) error
+}
+
+type service struct {
+	repository  Repository
+	redisClient *redis.Client
+	config      *config.Watcher
+}
+
+func NewService(repository Repository, redisClient *redis.Client, config *config.Watcher) Service {
+	return &service{repository, redisClient, config}
+}
+
+type generated struct {
+	archive []byte
+	err     error
+}
+
+// Export returns the user's archive if one is ready or can be built within the inline wait.
+// Otherwise generation continues in the background and the caller should ask again later.
+func (s *service) Export(ctx context.Context, userID uint) (Result, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "export.Service/Export")
+	defer span.End()
+
+	cfg := s.config.Current().Export
+
+	archive, err := s.redisClient.Get(ctx, archiveKey(userID)).Bytes()
+	if err == nil {
+		return Result{Ready: true, Archive: archive}, nil
+	}
+	if err != redis.Nil {
+		return Result{}, err
+	}
+
+	// Only one archive per user is generated at a time
+	started, err := s.redisClient.SetNX(ctx, pendingKey(userID), 1, generationTimeout).Result()
+	if err != nil {
+		return Result{}, err
+	}
+	if !started {
+		return Result{}, nil
+	}
+
+	// Keep generating after the request returns
+	done := make(chan generated, 1)
+	go func() {
+		archive, err := s.generate(context.WithoutCancel(ctx), userID, cfg.ArchiveTTL)
+		if err != nil {
+			log.Errorf("failed to export data of user %d: %v", userID, err)
+		}
+		done <- generated{archive, err}
+	}()
+
+	select {
+	case result := <-done:
+		if result.err != nil {
+			return Result{}, result.err
+		}
+		return Result{Ready: true, Archive: result.archive}, nil
+	case <-time.After(cfg.InlineWait):
+		return Result{}, nil
+	case <-ctx.Done():
+		return Result{}, ctx.Err()
+	}
+}
+
+// generate builds the archive and keeps it in Redis for ttl
+func (s *service) generate(ctx context.Context, userID uint, ttl time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, generationTimeout)
+	defer cancel()
+	defer s.redisClient.Del(ctx, pendingKey(userID))
+
+	archive, err := s.repository.GetArchive(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	archive.GeneratedAt = time.Now().UTC()
+
+	sessions, err := utils.GetSubjectSessions(ctx, s.redisClient, fmt.Sprintf("%d", userID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sessions: %w", err)
+	}
+	archive.Sessions = make([]Session, 0, len(sessions))
+	for _, session := range sessions {
+		archive.Sessions = append(archive.Sessions, Session{
+			ID:        fmt.Sprintf("%s", session.Member),
+			ExpiresAt: time.Unix(int64(session.Score), 0).UTC(),
+		})
+	}
+
+	data, err := json.MarshalIndent(archive, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode archive: %w", err)
+	}
+
+	err = s.redisClient.Set(ctx, archiveKey(userID), data, ttl).Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed to store archive: %w", err)
+	}
+	return data, nil
+}
+
+// DeleteArchive drops the user's cached archive, e.g. once their data is erased
+func (s *service) DeleteArchive(ctx context.Context, userID uint) error {
+	err := s.redisClient.Del(ctx, archiveKey(userID)).Err()
+	if err != nil {
+		return fmt.Errorf("failed to delete archive: %w", err)
+	}
+	return nil
+}
+
+func archiveKey(userID uint) string {
+	return fmt.Sprintf("%s%d", archiveKeyPrefix, userID)
+}
+
+func pendingKey(userID uint) string {
+	return fmt.Sprintf("%s%d", pendingKeyPrefix, userID)
+}
@@ -0,0 +1,33 @@
+package health
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type Handler interface {
+	Liveness(c echo.Context) (err error)
+	Readiness(c echo.Context) (err error)
+}
+
+type handler struct {
+	service Service
+}
+
+func NewHandler(service Service) Handler {
+	return handler{service}
+}
+
+func (h handler) Liveness(c echo.Context) (err error) {
+	return c.JSON(http.StatusOK, Liveness{Status: StatusOK})
+}
+
+func (h handler) Readiness(c echo.Context) (err error) {
+	readiness := h.service.Ready(c.Request().Context())
+	if readiness.Status != StatusOK {
+		return c.JSON(http.StatusServiceUnavailable, readiness)
+	}
+
+	return c.JSON(http.StatusOK, readiness)
+}
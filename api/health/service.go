@@ -0,0 +1,109 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+type Service interface {
+	Ready(ctx context.Context) Readiness
+	SetShuttingDown()
+}
+
+type service struct {
+	db           *gorm.DB
+	redisClient  *redis.Client
+	timeout      time.Duration
+	shuttingDown atomic.Bool
+}
+
+const defaultTimeout = 2 * time.Second
+
+func NewService(db *gorm.DB, redisClient *redis.Client, timeout time.Duration) Service {
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return &service{
+		db:          db,
+		redisClient: redisClient,
+		timeout:     timeout,
+	}
+}
+
+func (s *service) Ready(ctx context.Context) Readiness {
+	checks := map[string]func(context.Context) error{
+		"postgres": s.pingPostgres,
+		"redis":    s.pingRedis,
+	}
+
+	// Ping every dependency concurrently so one slow dependency doesn't delay the others
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	dependencies := make(map[string]DependencyStatus, len(checks))
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(context.Context) error) {
+			defer wg.Done()
+			status := ping(ctx, s.timeout, check)
+			mu.Lock()
+			dependencies[name] = status
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	readiness := Readiness{
+		Status:       StatusOK,
+		Dependencies: dependencies,
+	}
+	for _, dependency := range dependencies {
+		if dependency.Status != StatusOK {
+			readiness.Status = StatusFail
+		}
+	}
+
+	// Report not-ready while draining so load balancers stop sending traffic
+	if s.shuttingDown.Load() {
+		readiness.Status = StatusShuttingDown
+	}
+
+	return readiness
+}
+
+func (s *service) SetShuttingDown() {
+	s.shuttingDown.Store(true)
+}
+
+func (s *service) pingPostgres(ctx context.Context) error {
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+func (s *service) pingRedis(ctx context.Context) error {
+	return s.redisClient.Ping(ctx).Err()
+}
+
+func ping(ctx context.Context, timeout time.Duration, check func(context.Context) error) DependencyStatus {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check(ctx)
+	status := DependencyStatus{
+		Status:    StatusOK,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		status.Status = StatusFail
+		status.Error = err.Error()
+	}
+	return status
+}
@@ -0,0 +1,178 @@
+package oauth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.learning/middlewares"
+)
+
+type Handler interface {
+	CreateClient(c echo.Context) (err error)
+	GetClientList(c echo.Context) (err error)
+	DeleteClient(c echo.Context) (err error)
+	Authorize(c echo.Context) (err error)
+	Token(c echo.Context) (err error)
+	Introspect(c echo.Context) (err error)
+	Revoke(c echo.Context) (err error)
+}
+
+type handler struct {
+	service Service
+}
+
+func NewHandler(service Service) Handler {
+	return handler{service}
+}
+
+func (h handler) CreateClient(c echo.Context) (err error) {
+	userID, ok := middlewares.CurrentUserID(c)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, "User is not authenticated")
+	}
+
+	var req CreateClient
+	if err = c.Bind(&req); err != nil {
+		return
+	}
+
+	client, err := h.service.CreateClient(c.Request().Context(), userID, req)
+	if errors.Is(err, ErrNameRequired) || errors.Is(err, ErrRedirectURIRequired) || errors.Is(err, ErrInvalidRedirectURI) || errors.Is(err, ErrInvalidScope) {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return
+	}
+
+	return c.JSON(http.StatusCreated, client)
+}
+
+func (h handler) GetClientList(c echo.Context) (err error) {
+	userID, ok := middlewares.CurrentUserID(c)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, "User is not authenticated")
+	}
+
+	clients, err := h.service.GetClientList(c.Request().Context(), userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "Failed to get OAuth client list")
+	}
+
+	return c.JSON(http.StatusOK, clients)
+}
+
+func (h handler) DeleteClient(c echo.Context) (err error) {
+	userID, ok := middlewares.CurrentUserID(c)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, "User is not authenticated")
+	}
+
+	// Convert id to uint
+	var id uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &id); err != nil {
+		return c.JSON(http.StatusBadRequest, "Invalid ID format")
+	}
+
+	err = h.service.DeleteClient(c.Request().Context(), userID, id)
+	if errors.Is(err, ErrClientNotFound) {
+		return c.JSON(http.StatusNotFound, "OAuth client not found")
+	}
+	if err != nil {
+		return
+	}
+
+	return c.JSON(http.StatusNoContent, nil)
+}
+
+func (h handler) Authorize(c echo.Context) (err error) {
+	userID, ok := middlewares.CurrentUserID(c)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, "User is not authenticated")
+	}
+
+	var req Authorize
+	if err = c.Bind(&req); err != nil {
+		return
+	}
+
+	redirect, err := h.service.Authorize(c.Request().Context(), userID, req)
+	if err != nil {
+		return oauthError(c, err)
+	}
+
+	return c.Redirect(http.StatusFound, redirect)
+}
+
+func (h handler) Token(c echo.Context) (err error) {
+	var req Token
+	if err = c.Bind(&req); err != nil {
+		return
+	}
+
+	tokenResponse, err := h.service.Token(c.Request().Context(), clientCredentials(c), req)
+	if err != nil {
+		return oauthError(c, err)
+	}
+
+	// Token responses must never be cached
+	c.Response().Header().Set("Cache-Control", "no-store")
+	c.Response().Header().Set("Pragma", "no-cache")
+	return c.JSON(http.StatusOK, tokenResponse)
+}
+
+func (h handler) Introspect(c echo.Context) (err error) {
+	var req Introspect
+	if err = c.Bind(&req); err != nil {
+		return
+	}
+
+	introspectResponse, err := h.service.Introspect(c.Request().Context(), clientCredentials(c), req.Token)
+	if err != nil {
+		return oauthError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, introspectResponse)
+}
+
+func (h handler) Revoke(c echo.Context) (err error) {
+	var req Revoke
+	if err = c.Bind(&req); err != nil {
+		return
+	}
+
+	err = h.service.Revoke(c.Request().Context(), clientCredentials(c), req.Token)
+	if err != nil {
+		return oauthError(c, err)
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// clientCredentials reads HTTP Basic credentials, falling back to client_id and client_secret form fields
+func clientCredentials(c echo.Context) ClientCredentials {
+	if clientID, clientSecret, ok := c.Request().BasicAuth(); ok {
+		return ClientCredentials{ClientID: clientID, ClientSecret: clientSecret}
+	}
+	return ClientCredentials{
+		ClientID:     c.FormValue("client_id"),
+		ClientSecret: c.FormValue("client_secret"),
+	}
+}
+
+// oauthError writes err in the RFC 6749 error format
+func oauthError(c echo.Context, err error) error {
+	var oauthErr *Error
+	if !errors.As(err, &oauthErr) {
+		c.Logger().Errorf("oauth request failed: %v", err)
+		return c.JSON(http.StatusInternalServerError, &Error{Code: errServerError})
+	}
+
+	status := http.StatusBadRequest
+	if oauthErr.Code == errInvalidClient {
+		status = http.StatusUnauthorized
+		c.Response().Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	return c.JSON(status, oauthErr)
+}
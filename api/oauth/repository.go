@@ -0,0 +1,67 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.learning/models"
+	"gorm.io/gorm"
+)
+
+var ErrClientNotFound = errors.New("OAuth client not found")
+
+type Repository interface {
+	CreateClient(ctx context.Context, client *models.OAuthClient) error
+	GetClientList(ctx context.Context, userID uint) ([]models.OAuthClient, error)
+	GetClientByClientID(ctx context.Context, clientID string) (*models.OAuthClient, error)
+	DeleteClient(ctx context.Context, userID, id uint) error
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) *repository {
+	return &repository{db}
+}
+
+func (r *repository) CreateClient(ctx context.Context, client *models.OAuthClient) error {
+	err := r.db.WithContext(ctx).Create(client).Error
+	if err != nil {
+		return fmt.Errorf("failed to create OAuth client: %w", err)
+	}
+	return nil
+}
+
+func (r *repository) GetClientList(ctx context.Context, userID uint) ([]models.OAuthClient, error) {
+	var clients []models.OAuthClient
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at DESC").Find(&clients).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get OAuth client list: %w", err)
+	}
+	return clients, nil
+}
+
+func (r *repository) GetClientByClientID(ctx context.Context, clientID string) (*models.OAuthClient, error) {
+	var client models.OAuthClient
+	err := r.db.WithContext(ctx).Where("client_id = ?", clientID).First(&client).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrClientNotFound
+		}
+		return nil, fmt.Errorf("failed to get OAuth client: %w", err)
+	}
+	return &client, nil
+}
+
+func (r *repository) DeleteClient(ctx context.Context, userID, id uint) error {
+	result := r.db.WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).Delete(&models.OAuthClient{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete OAuth client: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrClientNotFound
+	}
+	return nil
+}
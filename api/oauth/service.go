@@ -0,0 +1,499 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.learning/api/apikey"
+	"go.learning/api/auth"
+	"go.learning/config"
+	"go.learning/models"
+	"go.learning/tracing"
+	"go.learning/utils"
+)
+
+const (
+	codeKeyPrefix = "oauth:code:"
+
+	// codeTTL is how long an authorization code can be exchanged for tokens
+	codeTTL = time.Minute
+
+	// codeChallengeMethod is the only PKCE method accepted; plain challenges give no protection
+	codeChallengeMethod = "S256"
+)
+
+var (
+	ErrNameRequired        = errors.New("name is required")
+	ErrInvalidRedirectURI  = errors.New("redirect URIs must be absolute URLs without a fragment")
+	ErrRedirectURIRequired = errors.New("at least one redirect URI is required")
+	ErrInvalidScope        = errors.New("invalid scope")
+)
+
+type Service interface {
+	CreateClient(ctx context.Context, userID uint, req CreateClient) (*CreateClientResponse, error)
+	GetClientList(ctx context.Context, userID uint) ([]Client, error)
+	DeleteClient(ctx context.Context, userID, id uint) error
+	Authorize(ctx context.Context, userID uint, req Authorize) (string, error)
+	Token(ctx context.Context, credentials ClientCredentials, req Token) (*TokenResponse, error)
+	Introspect(ctx context.Context, credentials ClientCredentials, token string) (IntrospectResponse, error)
+	Revoke(ctx context.Context, credentials ClientCredentials, token string) error
+}
+
+type service struct {
+	repository  Repository
+	authService auth.Service
+	redisClient *redis.Client
+	config      *config.Watcher
+}
+
+func NewService(repository Repository, authService auth.Service, redisClient *redis.Client, config *config.Watcher) Service {
+	return &service{
+		repository:  repository,
+		authService: authService,
+		redisClient: redisClient,
+		config:      config,
+	}
+}
+
+func (s *service) CreateClient(ctx context.Context, userID uint, req CreateClient) (*CreateClientResponse, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "oauth.Service/CreateClient")
+	defer span.End()
+
+	// Validate the request
+	if strings.TrimSpace(req.Name) == "" {
+		return nil, ErrNameRequired
+	}
+	if len(req.RedirectURIs) == 0 {
+		return nil, ErrRedirectURIRequired
+	}
+	for _, uri := range req.RedirectURIs {
+		if !validRedirectURI(uri) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidRedirectURI, uri)
+		}
+	}
+	// Grants are limited to these scopes by RequireScope, so clients can only register scopes it knows
+	for _, scope := range req.Scopes {
+		if !apikey.IsValidScope(scope) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidScope, scope)
+		}
+	}
+
+	// Generate the credentials; only the secret's hash is stored
+	clientID, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+
+	client := &models.OAuthClient{
+		UserID:       userID,
+		Name:         req.Name,
+		ClientID:     clientID,
+		HashedSecret: hashSecret(secret),
+		RedirectURIs: strings.Join(req.RedirectURIs, " "),
+		Scopes:       strings.Join(req.Scopes, " "),
+	}
+	err = s.repository.CreateClient(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CreateClientResponse{
+		Client:       toClient(*client),
+		ClientSecret: secret,
+	}, nil
+}
+
+func (s *service) GetClientList(ctx context.Context, userID uint) ([]Client, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "oauth.Service/GetClientList")
+	defer span.End()
+
+	clients, err := s.repository.GetClientList(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	clientList := []Client{}
+	for _, client := range clients {
+		clientList = append(clientList, toClient(client))
+	}
+	return clientList, nil
+}
+
+func (s *service) DeleteClient(ctx context.Context, userID, id uint) error {
+	ctx, span := tracing.Tracer.Start(ctx, "oauth.Service/DeleteClient")
+	defer span.End()
+
+	return s.repository.DeleteClient(ctx, userID, id)
+}
+
+// Authorize issues an authorization code for the signed-in user and returns the client redirect.
+// Clients are first-party, so consent is implicit. An *Error is returned instead of a redirect
+// when the client or redirect URI can't be trusted.
+func (s *service) Authorize(ctx context.Context, userID uint, req Authorize) (string, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "oauth.Service/Authorize")
+	defer span.End()
+
+	client, err := s.repository.GetClientByClientID(ctx, req.ClientID)
+	if errors.Is(err, ErrClientNotFound) {
+		return "", &Error{Code: errInvalidClient, Description: "unknown client_id"}
+	}
+	if err != nil {
+		return "", err
+	}
+	if !client.AllowsRedirectURI(req.RedirectURI) {
+		return "", &Error{Code: errInvalidRequest, Description: "redirect_uri is not registered for this client"}
+	}
+
+	// From here on errors are reported to the client through the redirect
+	if req.ResponseType != "code" {
+		return redirectURL(req.RedirectURI, url.Values{"error": {errUnsupportedResponseType}, "state": {req.State}}), nil
+	}
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != codeChallengeMethod {
+		return redirectURL(req.RedirectURI, url.Values{
+			"error":             {errInvalidRequest},
+			"error_description": {"PKCE with code_challenge_method S256 is required"},
+			"state":             {req.State},
+		}), nil
+	}
+	scope, err := grantedScope(client, req.Scope)
+	if err != nil {
+		return redirectURL(req.RedirectURI, url.Values{"error": {errInvalidScope}, "state": {req.State}}), nil
+	}
+
+	code, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(authorizationCode{
+		ClientID:      client.ClientID,
+		UserID:        userID,
+		RedirectURI:   req.RedirectURI,
+		Scope:         scope,
+		CodeChallenge: req.CodeChallenge,
+	})
+	if err != nil {
+		return "", err
+	}
+	err = s.redisClient.Set(ctx, codeKeyPrefix+code, payload, codeTTL).Err()
+	if err != nil {
+		return "", fmt.Errorf("failed to store authorization code: %w", err)
+	}
+
+	return redirectURL(req.RedirectURI, url.Values{"code": {code}, "state": {req.State}}), nil
+}
+
+// Token exchanges a grant for tokens issued through the regular session infrastructure
+func (s *service) Token(ctx context.Context, credentials ClientCredentials, req Token) (*TokenResponse, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "oauth.Service/Token")
+	defer span.End()
+
+	client, err := s.authenticateClient(ctx, credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	switch req.GrantType {
+	case GrantTypeAuthorizationCode:
+		return s.exchangeCode(ctx, client, req)
+	case GrantTypeClientCredentials:
+		return s.clientCredentials(ctx, client, req)
+	case GrantTypeRefreshToken:
+		return s.refresh(ctx, client, req)
+	default:
+		return nil, &Error{Code: errUnsupportedGrantType}
+	}
+}
+
+func (s *service) exchangeCode(ctx context.Context, client *models.OAuthClient, req Token) (*TokenResponse, error) {
+	// The code is single use
+	if req.Code == "" {
+		return nil, &Error{Code: errInvalidRequest, Description: "code is required"}
+	}
+	payload, err := s.redisClient.GetDel(ctx, codeKeyPrefix+req.Code).Bytes()
+	if err == redis.Nil {
+		return nil, &Error{Code: errInvalidGrant, Description: "authorization code is invalid or expired"}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load authorization code: %w", err)
+	}
+	var code authorizationCode
+	if err := json.Unmarshal(payload, &code); err != nil {
+		return nil, &Error{Code: errInvalidGrant, Description: "authorization code is invalid or expired"}
+	}
+
+	if code.ClientID != client.ClientID || code.RedirectURI != req.RedirectURI {
+		return nil, &Error{Code: errInvalidGrant, Description: "authorization code was issued to another client or redirect_uri"}
+	}
+	if !verifyCodeChallenge(req.CodeVerifier, code.CodeChallenge) {
+		return nil, &Error{Code: errInvalidGrant, Description: "code_verifier does not match code_challenge"}
+	}
+
+	session, err := s.authService.CreateSession(ctx, code.UserID)
+	if errors.Is(err, auth.ErrUserInactive) {
+		return nil, &Error{Code: errInvalidGrant, Description: "user account is deactivated"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.issue(ctx, client, code.Scope, session.AccessToken, session.RefreshToken, session.ExpiresAt)
+}
+
+func (s *service) clientCredentials(ctx context.Context, client *models.OAuthClient, req Token) (*TokenResponse, error) {
+	scope, err := grantedScope(client, req.Scope)
+	if err != nil {
+		return nil, &Error{Code: errInvalidScope, Description: err.Error()}
+	}
+
+	session, err := s.authService.CreateClientSession(ctx, client.ClientID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Clients can always authenticate again, so they get no refresh token
+	return s.issue(ctx, client, scope, session.AccessToken, "", session.ExpiresAt)
+}
+
+func (s *service) refresh(ctx context.Context, client *models.OAuthClient, req Token) (*TokenResponse, error) {
+	tokenGrant, _, err := s.lookupGrant(ctx, req.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+	if tokenGrant == nil || tokenGrant.ClientID != client.ClientID {
+		return nil, &Error{Code: errInvalidGrant, Description: "refresh token is invalid or was issued to another client"}
+	}
+
+	refreshed, err := s.authService.RefreshClientToken(ctx, req.RefreshToken)
+	if err != nil {
+		return nil, &Error{Code: errInvalidGrant, Description: err.Error()}
+	}
+	return s.issue(ctx, client, tokenGrant.Scope, refreshed.AccessToken, refreshed.RefreshToken, refreshed.ExpiresAt)
+}
+
+// issue records the grant against the session and builds the token response
+func (s *service) issue(ctx context.Context, client *models.OAuthClient, scope, accessToken, refreshToken string, expiresAt int64) (*TokenResponse, error) {
+	jwtConfig := s.config.Current().JWT
+
+	claims, err := utils.ValidateJWT(jwtConfig.SecretKey, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	sessionId := fmt.Sprintf("%s", (*claims)["sessionId"])
+
+	err = utils.StoreOAuthGrant(ctx, s.redisClient, sessionId, utils.OAuthGrant{ClientID: client.ClientID, Scope: scope}, jwtConfig.RefreshTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    expiresAt - time.Now().Unix(),
+		RefreshToken: refreshToken,
+		Scope:        scope,
+	}, nil
+}
+
+// Introspect reports whether a token is currently usable and who it was issued to
+func (s *service) Introspect(ctx context.Context, credentials ClientCredentials, token string) (IntrospectResponse, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "oauth.Service/Introspect")
+	defer span.End()
+
+	if _, err := s.authenticateClient(ctx, credentials); err != nil {
+		return IntrospectResponse{}, err
+	}
+
+	claims, err := utils.ValidateJWT(s.config.Current().JWT.SecretKey, token)
+	if err != nil {
+		return IntrospectResponse{Active: false}, nil
+	}
+	sessionId := fmt.Sprintf("%s", (*claims)["sessionId"])
+
+	revoked, err := utils.IsSessionRevoked(ctx, s.redisClient, sessionId)
+	if err != nil {
+		return IntrospectResponse{}, err
+	}
+	if revoked {
+		return IntrospectResponse{Active: false}, nil
+	}
+
+	// Access tokens are only active while they are the session's current token
+	tokenType := utils.TokenType(*claims)
+	if tokenType != utils.TokenTypeRefresh {
+		storedToken, err := utils.CheckSessionInRedis(ctx, s.redisClient, sessionId)
+		if err == redis.Nil || (err == nil && storedToken != token) {
+			return IntrospectResponse{Active: false}, nil
+		}
+		if err != nil {
+			return IntrospectResponse{}, err
+		}
+		tokenType = utils.TokenTypeAccess
+	}
+
+	response := IntrospectResponse{
+		Active:    true,
+		Subject:   fmt.Sprintf("%s", (*claims)["userID"]),
+		TokenType: tokenType + "_token",
+	}
+	if exp, ok := (*claims)["exp"].(float64); ok {
+		response.ExpiresAt = int64(exp)
+	}
+
+	// Tokens from a first-party login have no grant
+	tokenGrant, err := utils.GetOAuthGrant(ctx, s.redisClient, sessionId)
+	if err != nil {
+		return IntrospectResponse{}, err
+	}
+	if tokenGrant != nil {
+		response.ClientID = tokenGrant.ClientID
+		response.Scope = tokenGrant.Scope
+	}
+	return response, nil
+}
+
+// Revoke ends the session behind a token issued to the client. Unknown tokens are ignored as RFC 7009 requires.
+func (s *service) Revoke(ctx context.Context, credentials ClientCredentials, token string) error {
+	ctx, span := tracing.Tracer.Start(ctx, "oauth.Service/Revoke")
+	defer span.End()
+
+	client, err := s.authenticateClient(ctx, credentials)
+	if err != nil {
+		return err
+	}
+
+	tokenGrant, sessionId, err := s.lookupGrant(ctx, token)
+	if err != nil || sessionId == "" {
+		return err
+	}
+	if tokenGrant == nil || tokenGrant.ClientID != client.ClientID {
+		return &Error{Code: errUnauthorizedClient, Description: "token was not issued to this client"}
+	}
+
+	err = s.authService.RevokeSession(ctx, token)
+	if err != nil {
+		return err
+	}
+	return utils.DeleteOAuthGrant(ctx, s.redisClient, sessionId)
+}
+
+// lookupGrant returns the grant and session behind a token; the session ID is empty when the token is invalid
+func (s *service) lookupGrant(ctx context.Context, token string) (*utils.OAuthGrant, string, error) {
+	claims, err := utils.ValidateJWT(s.config.Current().JWT.SecretKey, token)
+	if err != nil {
+		return nil, "", nil
+	}
+	sessionId := fmt.Sprintf("%s", (*claims)["sessionId"])
+
+	tokenGrant, err := utils.GetOAuthGrant(ctx, s.redisClient, sessionId)
+	return tokenGrant, sessionId, err
+}
+
+func (s *service) authenticateClient(ctx context.Context, credentials ClientCredentials) (*models.OAuthClient, error) {
+	invalidClient := &Error{Code: errInvalidClient, Description: "client authentication failed"}
+	if credentials.ClientID == "" || credentials.ClientSecret == "" {
+		return nil, invalidClient
+	}
+
+	client, err := s.repository.GetClientByClientID(ctx, credentials.ClientID)
+	if errors.Is(err, ErrClientNotFound) {
+		return nil, invalidClient
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(client.HashedSecret), []byte(hashSecret(credentials.ClientSecret))) != 1 {
+		return nil, invalidClient
+	}
+	return client, nil
+}
+
+// grantedScope checks the requested scope against the client's registration; an empty request grants every registered scope
+func grantedScope(client *models.OAuthClient, requested string) (string, error) {
+	scopes := strings.Fields(requested)
+	if len(scopes) == 0 {
+		return client.Scopes, nil
+	}
+	for _, scope := range scopes {
+		if !client.HasScope(scope) {
+			return "", fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+		}
+	}
+	return strings.Join(scopes, " "), nil
+}
+
+func verifyCodeChallenge(verifier, challenge string) bool {
+	if verifier == "" {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+func validRedirectURI(uri string) bool {
+	parsed, err := url.Parse(uri)
+	return err == nil && parsed.IsAbs() && parsed.Host != "" && parsed.Fragment == ""
+}
+
+// redirectURL appends params to the client's redirect URI, keeping its own query
+func redirectURL(redirectURI string, params url.Values) string {
+	parsed, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+	query := parsed.Query()
+	for key, values := range params {
+		if len(values) > 0 && values[0] != "" {
+			query.Set(key, values[0])
+		}
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random value: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random value: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func toClient(client models.OAuthClient) Client {
+	return Client{
+		ID:           client.ID,
+		Name:         client.Name,
+		ClientID:     client.ClientID,
+		RedirectURIs: client.RedirectURIList(),
+		Scopes:       client.ScopeList(),
+		CreatedAt:    client.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+}
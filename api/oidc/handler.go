@@ -0,0 +1,71 @@
+package oidc
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.learning/api/auth"
+	"go.learning/middlewares"
+)
+
+type Handler interface {
+	Login(c echo.Context) (err error)
+	Callback(c echo.Context) (err error)
+	GetIdentityList(c echo.Context) (err error)
+}
+
+type handler struct {
+	service Service
+}
+
+func NewHandler(service Service) Handler {
+	return handler{service}
+}
+
+func (h handler) Login(c echo.Context) (err error) {
+	url, err := h.service.AuthCodeURL(c.Request().Context(), c.Param("provider"))
+	if errors.Is(err, ErrUnknownProvider) {
+		return c.JSON(http.StatusNotFound, "Unknown provider")
+	}
+	if err != nil {
+		return
+	}
+
+	return c.Redirect(http.StatusFound, url)
+}
+
+func (h handler) Callback(c echo.Context) (err error) {
+	var req Callback
+	if err = c.Bind(&req); err != nil {
+		return
+	}
+
+	loginResponse, err := h.service.Callback(c.Request().Context(), c.Param("provider"), req)
+	switch {
+	case errors.Is(err, ErrUnknownProvider):
+		return c.JSON(http.StatusNotFound, "Unknown provider")
+	case errors.Is(err, ErrInvalidState), errors.Is(err, ErrInvalidToken):
+		return c.JSON(http.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrEmailNotVerified), errors.Is(err, auth.ErrUserInactive):
+		return c.JSON(http.StatusForbidden, err.Error())
+	case err != nil:
+		return
+	}
+
+	return c.JSON(http.StatusOK, loginResponse)
+}
+
+func (h handler) GetIdentityList(c echo.Context) (err error) {
+	userID, ok := middlewares.CurrentUserID(c)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, "User is not authenticated")
+	}
+
+	identities, err := h.service.GetIdentityList(c.Request().Context(), userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "Failed to get identity list")
+	}
+
+	return c.JSON(http.StatusOK, identities)
+}
@@ -0,0 +1,70 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.learning/models"
+	"gorm.io/gorm"
+)
+
+var ErrIdentityNotFound = errors.New("identity not found")
+
+type Repository interface {
+	GetIdentity(ctx context.Context, provider, subject string) (*models.UserIdentity, error)
+	GetIdentityList(ctx context.Context, userID uint) ([]models.UserIdentity, error)
+	CreateIdentity(ctx context.Context, identity *models.UserIdentity) error
+	CreateUserWithIdentity(ctx context.Context, user *models.User, identity *models.UserIdentity) error
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) *repository {
+	return &repository{db}
+}
+
+func (r *repository) GetIdentity(ctx context.Context, provider, subject string) (*models.UserIdentity, error) {
+	var identity models.UserIdentity
+	err := r.db.WithContext(ctx).Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrIdentityNotFound
+		}
+		return nil, fmt.Errorf("failed to get identity: %w", err)
+	}
+	return &identity, nil
+}
+
+func (r *repository) GetIdentityList(ctx context.Context, userID uint) ([]models.UserIdentity, error) {
+	var identities []models.UserIdentity
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at ASC").Find(&identities).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get identity list: %w", err)
+	}
+	return identities, nil
+}
+
+func (r *repository) CreateIdentity(ctx context.Context, identity *models.UserIdentity) error {
+	err := r.db.WithContext(ctx).Create(identity).Error
+	if err != nil {
+		return fmt.Errorf("failed to create identity: %w", err)
+	}
+	return nil
+}
+
+// CreateUserWithIdentity creates a user and its first linked identity atomically
+func (r *repository) CreateUserWithIdentity(ctx context.Context, user *models.User, identity *models.UserIdentity) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return fmt.Errorf("failed to create user: %w", err)
+		}
+		identity.UserID = user.ID
+		if err := tx.Create(identity).Error; err != nil {
+			return fmt.Errorf("failed to create identity: %w", err)
+		}
+		return nil
+	})
+}
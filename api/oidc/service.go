@@ -0,0 +1,267 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-redis/redis/v8"
+	"go.learning/api/auth"
+	"go.learning/api/user"
+	"go.learning/config"
+	"go.learning/models"
+	"go.learning/tracing"
+	"golang.org/x/oauth2"
+)
+
+const (
+	stateKeyPrefix = "oidc:state:"
+	stateTTL       = 10 * time.Minute
+)
+
+var (
+	ErrUnknownProvider  = errors.New("unknown OIDC provider")
+	ErrInvalidState     = errors.New("invalid or expired login state")
+	ErrInvalidToken     = errors.New("invalid ID token")
+	ErrEmailNotVerified = errors.New("provider did not return a verified email")
+)
+
+type Service interface {
+	AuthCodeURL(ctx context.Context, provider string) (string, error)
+	Callback(ctx context.Context, provider string, callback Callback) (auth.LoginResponse, error)
+	GetIdentityList(ctx context.Context, userID uint) ([]Identity, error)
+}
+
+type service struct {
+	repository     Repository
+	userRepository user.Repository
+	authService    auth.Service
+	redisClient    *redis.Client
+	providers      map[string]config.OIDCProvider
+
+	// Discovery documents are fetched on first use so a provider outage doesn't block startup
+	mu         sync.Mutex
+	discovered map[string]*gooidc.Provider
+}
+
+func NewService(repository Repository, userRepository user.Repository, authService auth.Service, redisClient *redis.Client, cfg config.OIDC) Service {
+	return &service{
+		repository:     repository,
+		userRepository: userRepository,
+		authService:    authService,
+		redisClient:    redisClient,
+		providers:      cfg.Providers,
+		discovered:     make(map[string]*gooidc.Provider),
+	}
+}
+
+// AuthCodeURL starts the authorization code flow with PKCE, state and nonce
+func (s *service) AuthCodeURL(ctx context.Context, provider string) (string, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "oidc.Service/AuthCodeURL")
+	defer span.End()
+
+	oauthConfig, _, err := s.client(ctx, provider)
+	if err != nil {
+		return "", err
+	}
+
+	state, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	// Remember the nonce and verifier until the provider redirects back
+	payload, err := json.Marshal(loginState{Provider: provider, Nonce: nonce, CodeVerifier: verifier})
+	if err != nil {
+		return "", err
+	}
+	err = s.redisClient.Set(ctx, stateKeyPrefix+state, payload, stateTTL).Err()
+	if err != nil {
+		return "", fmt.Errorf("failed to store login state: %w", err)
+	}
+
+	return oauthConfig.AuthCodeURL(state, gooidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), nil
+}
+
+// Callback completes the flow and signs the user in, linking or creating the account as needed
+func (s *service) Callback(ctx context.Context, provider string, callback Callback) (auth.LoginResponse, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "oidc.Service/Callback")
+	defer span.End()
+
+	if callback.Error != "" {
+		return auth.LoginResponse{}, fmt.Errorf("provider returned %s: %s", callback.Error, callback.ErrorDescription)
+	}
+
+	oauthConfig, verifier, err := s.client(ctx, provider)
+	if err != nil {
+		return auth.LoginResponse{}, err
+	}
+
+	// The state is single use
+	if callback.State == "" {
+		return auth.LoginResponse{}, ErrInvalidState
+	}
+	payload, err := s.redisClient.GetDel(ctx, stateKeyPrefix+callback.State).Bytes()
+	if err == redis.Nil {
+		return auth.LoginResponse{}, ErrInvalidState
+	}
+	if err != nil {
+		return auth.LoginResponse{}, fmt.Errorf("failed to load login state: %w", err)
+	}
+	var state loginState
+	if err := json.Unmarshal(payload, &state); err != nil || state.Provider != provider {
+		return auth.LoginResponse{}, ErrInvalidState
+	}
+
+	// Exchange the code and verify the ID token
+	token, err := oauthConfig.Exchange(ctx, callback.Code, oauth2.VerifierOption(state.CodeVerifier))
+	if err != nil {
+		return auth.LoginResponse{}, fmt.Errorf("failed to exchange code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return auth.LoginResponse{}, ErrInvalidToken
+	}
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return auth.LoginResponse{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	var claims idTokenClaims
+	if err := idToken.Claims(&claims); err != nil {
+		return auth.LoginResponse{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if claims.Nonce != state.Nonce {
+		return auth.LoginResponse{}, fmt.Errorf("%w: nonce mismatch", ErrInvalidToken)
+	}
+
+	userID, err := s.resolveUser(ctx, provider, claims)
+	if err != nil {
+		return auth.LoginResponse{}, err
+	}
+
+	return s.authService.CreateSession(ctx, userID)
+}
+
+// resolveUser finds the user linked to the identity, linking by verified email or creating the user on first login
+func (s *service) resolveUser(ctx context.Context, provider string, claims idTokenClaims) (uint, error) {
+	identity, err := s.repository.GetIdentity(ctx, provider, claims.Subject)
+	if err == nil {
+		return identity.UserID, nil
+	}
+	if !errors.Is(err, ErrIdentityNotFound) {
+		return 0, err
+	}
+
+	// Only a verified email is trusted to prove ownership of an account
+	if claims.Email == "" || !claims.EmailVerified {
+		return 0, ErrEmailNotVerified
+	}
+	identity = &models.UserIdentity{
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}
+
+	existing, err := s.userRepository.GetUserByEmail(ctx, claims.Email)
+	if err == nil {
+		identity.UserID = existing.ID
+		if err := s.repository.CreateIdentity(ctx, identity); err != nil {
+			return 0, err
+		}
+		return existing.ID, nil
+	}
+	if !errors.Is(err, user.ErrUserNotFound) {
+		return 0, err
+	}
+
+	// Users created through a provider have no password until they set one
+	newUser := &models.User{
+		Email:     claims.Email,
+		FirstName: claims.GivenName,
+		LastName:  claims.FamilyName,
+		Active:    true,
+	}
+	if err := s.repository.CreateUserWithIdentity(ctx, newUser, identity); err != nil {
+		return 0, err
+	}
+	return newUser.ID, nil
+}
+
+func (s *service) GetIdentityList(ctx context.Context, userID uint) ([]Identity, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "oidc.Service/GetIdentityList")
+	defer span.End()
+
+	identities, err := s.repository.GetIdentityList(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	identityList := []Identity{}
+	for _, identity := range identities {
+		identityList = append(identityList, Identity{
+			ID:        identity.ID,
+			Provider:  identity.Provider,
+			Email:     identity.Email,
+			CreatedAt: identity.CreatedAt.Format("2006-01-02 15:04:05"),
+		})
+	}
+	return identityList, nil
+}
+
+// client returns the OAuth2 config and ID token verifier for a configured provider
+func (s *service) client(ctx context.Context, name string) (*oauth2.Config, *gooidc.IDTokenVerifier, error) {
+	providerConfig, ok := s.providers[name]
+	if !ok {
+		return nil, nil, ErrUnknownProvider
+	}
+
+	s.mu.Lock()
+	provider, ok := s.discovered[name]
+	s.mu.Unlock()
+	if !ok {
+		var err error
+		provider, err = gooidc.NewProvider(ctx, providerConfig.IssuerURL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to discover OIDC provider %s: %w", name, err)
+		}
+		s.mu.Lock()
+		s.discovered[name] = provider
+		s.mu.Unlock()
+	}
+
+	scopes := providerConfig.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{gooidc.ScopeOpenID, "email", "profile"}
+	}
+
+	oauthConfig := &oauth2.Config{
+		ClientID:     providerConfig.ClientID,
+		ClientSecret: providerConfig.ClientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  providerConfig.RedirectURL,
+		Scopes:       scopes,
+	}
+	verifier := provider.Verifier(&gooidc.Config{ClientID: providerConfig.ClientID})
+
+	return oauthConfig, verifier, nil
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random value: %w", err)
+	}
+	return strings.TrimRight(base64.URLEncoding.EncodeToString(b), "="), nil
+}
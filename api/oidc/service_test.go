@@ -0,0 +1,487 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-redis/redis/v8"
+	"go.learning/api/auth"
+	"go.learning/api/user"
+	"go.learning/config"
+	"go.learning/models"
+)
+
+const testClientID = "test-client"
+
+// mockIssuer is an OpenID provider that answers discovery, JWKS and token requests.
+// The token endpoint signs an ID token with the claims set by the test.
+type mockIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	claims func(nonce string) map[string]interface{}
+	codes  map[string]authRequest // issued codes and the request they answer
+}
+
+type authRequest struct {
+	nonce         string
+	codeChallenge string
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	m := &mockIssuer{key: key, codes: make(map[string]authRequest)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"issuer":                                m.URL,
+			"authorization_endpoint":                m.URL + "/authorize",
+			"token_endpoint":                        m.URL + "/token",
+			"jwks_uri":                              m.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		m.mu.Lock()
+		req, ok := m.codes[r.PostForm.Get("code")]
+		delete(m.codes, r.PostForm.Get("code"))
+		claims := m.claims
+		m.mu.Unlock()
+
+		// PKCE: the verifier must hash to the challenge sent with the authorization request
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != req.codeChallenge {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		writeJSON(w, map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     m.sign(t, claims(req.nonce)),
+		})
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+// authorize plays the user agent at the authorization endpoint and returns the callback the provider would redirect to
+func (m *mockIssuer) authorize(t *testing.T, authURL string) Callback {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("parse auth URL: %v", err)
+	}
+	query := u.Query()
+	if query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("auth URL %s doesn't use PKCE with S256", authURL)
+	}
+
+	code := "code-" + query.Get("state")
+	m.mu.Lock()
+	m.codes[code] = authRequest{nonce: query.Get("nonce"), codeChallenge: query.Get("code_challenge")}
+	m.mu.Unlock()
+	return Callback{Code: code, State: query.Get("state")}
+}
+
+func (m *mockIssuer) setClaims(claims func(nonce string) map[string]interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.claims = claims
+}
+
+func (m *mockIssuer) sign(t *testing.T, claims map[string]interface{}) string {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: m.key, KeyID: "test"}}, nil)
+	if err != nil {
+		t.Errorf("create signer: %v", err)
+		return ""
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Errorf("encode claims: %v", err)
+		return ""
+	}
+	signed, err := signer.Sign(payload)
+	if err != nil {
+		t.Errorf("sign ID token: %v", err)
+		return ""
+	}
+	token, err := signed.CompactSerialize()
+	if err != nil {
+		t.Errorf("serialize ID token: %v", err)
+	}
+	return token
+}
+
+// idToken returns the standard claims for a token issued to the test client
+func (m *mockIssuer) idToken(nonce, subject, email string, emailVerified bool) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":            m.URL,
+		"aud":            testClientID,
+		"sub":            subject,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"nonce":          nonce,
+		"email":          email,
+		"email_verified": emailVerified,
+		"given_name":     "Ada",
+		"family_name":    "Lovelace",
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// fakeRepository keeps identities and users created through a provider in memory
+type fakeRepository struct {
+	identities []models.UserIdentity
+	users      *fakeUserRepository
+}
+
+func (r *fakeRepository) GetIdentity(ctx context.Context, provider, subject string) (*models.UserIdentity, error) {
+	for _, identity := range r.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return &identity, nil
+		}
+	}
+	return nil, ErrIdentityNotFound
+}
+
+func (r *fakeRepository) GetIdentityList(ctx context.Context, userID uint) ([]models.UserIdentity, error) {
+	var identities []models.UserIdentity
+	for _, identity := range r.identities {
+		if identity.UserID == userID {
+			identities = append(identities, identity)
+		}
+	}
+	return identities, nil
+}
+
+func (r *fakeRepository) CreateIdentity(ctx context.Context, identity *models.UserIdentity) error {
+	identity.ID = uint(len(r.identities) + 1)
+	r.identities = append(r.identities, *identity)
+	return nil
+}
+
+func (r *fakeRepository) CreateUserWithIdentity(ctx context.Context, u *models.User, identity *models.UserIdentity) error {
+	r.users.add(u)
+	identity.UserID = u.ID
+	return r.CreateIdentity(ctx, identity)
+}
+
+// fakeUserRepository implements the user lookups the OIDC service makes
+type fakeUserRepository struct {
+	user.Repository
+	users []*models.User
+}
+
+func (r *fakeUserRepository) add(u *models.User) {
+	u.ID = uint(len(r.users) + 1)
+	r.users = append(r.users, u)
+}
+
+func (r *fakeUserRepository) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	for _, u := range r.users {
+		if strings.EqualFold(u.Email, email) {
+			return u, nil
+		}
+	}
+	return nil, user.ErrUserNotFound
+}
+
+// fakeAuthService records which users were signed in
+type fakeAuthService struct {
+	auth.Service
+	sessions []uint
+}
+
+func (s *fakeAuthService) CreateSession(ctx context.Context, userID uint) (auth.LoginResponse, error) {
+	s.sessions = append(s.sessions, userID)
+	return auth.LoginResponse{AccessToken: "token"}, nil
+}
+
+type testEnv struct {
+	issuer   *mockIssuer
+	service  Service
+	repo     *fakeRepository
+	users    *fakeUserRepository
+	sessions *fakeAuthService
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	issuer := newMockIssuer(t)
+
+	redisServer := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+	t.Cleanup(func() { redisClient.Close() })
+
+	provider := config.OIDCProvider{
+		IssuerURL:   issuer.URL,
+		ClientID:    testClientID,
+		RedirectURL: "http://localhost/auth/oidc/test/callback",
+	}
+	users := &fakeUserRepository{}
+	repo := &fakeRepository{users: users}
+	sessions := &fakeAuthService{}
+	cfg := config.OIDC{Providers: map[string]config.OIDCProvider{"test": provider, "other": provider}}
+
+	return &testEnv{
+		issuer:   issuer,
+		service:  NewService(repo, users, sessions, redisClient, cfg),
+		repo:     repo,
+		users:    users,
+		sessions: sessions,
+	}
+}
+
+// login runs the flow up to the callback the provider redirects to
+func (e *testEnv) login(t *testing.T, provider string) Callback {
+	t.Helper()
+	authURL, err := e.service.AuthCodeURL(context.Background(), provider)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	return e.issuer.authorize(t, authURL)
+}
+
+func TestCallback(t *testing.T) {
+	tests := []struct {
+		name          string
+		existingUsers []*models.User
+		identities    []models.UserIdentity
+		claims        func(m *mockIssuer, nonce string) map[string]interface{}
+		wantErr       error
+		wantUserID    uint
+		wantUsers     int
+		wantLinked    []models.UserIdentity
+	}{
+		{
+			name: "first login creates the user",
+			claims: func(m *mockIssuer, nonce string) map[string]interface{} {
+				return m.idToken(nonce, "sub-1", "ada@example.com", true)
+			},
+			wantUserID: 1,
+			wantUsers:  1,
+			wantLinked: []models.UserIdentity{{Provider: "test", Subject: "sub-1", Email: "ada@example.com", UserID: 1}},
+		},
+		{
+			name:          "verified email links the existing user",
+			existingUsers: []*models.User{{Email: "someone@example.com"}, {Email: "Ada@Example.com"}},
+			claims: func(m *mockIssuer, nonce string) map[string]interface{} {
+				return m.idToken(nonce, "sub-1", "ada@example.com", true)
+			},
+			wantUserID: 2,
+			wantUsers:  2,
+			wantLinked: []models.UserIdentity{{Provider: "test", Subject: "sub-1", Email: "ada@example.com", UserID: 2}},
+		},
+		{
+			name:          "unverified email is not linked",
+			existingUsers: []*models.User{{Email: "ada@example.com"}},
+			claims: func(m *mockIssuer, nonce string) map[string]interface{} {
+				return m.idToken(nonce, "sub-1", "ada@example.com", false)
+			},
+			wantErr:   ErrEmailNotVerified,
+			wantUsers: 1,
+		},
+		{
+			name: "unverified email creates no user",
+			claims: func(m *mockIssuer, nonce string) map[string]interface{} {
+				return m.idToken(nonce, "sub-1", "ada@example.com", false)
+			},
+			wantErr: ErrEmailNotVerified,
+		},
+		{
+			name: "missing email creates no user",
+			claims: func(m *mockIssuer, nonce string) map[string]interface{} {
+				return m.idToken(nonce, "sub-1", "", true)
+			},
+			wantErr: ErrEmailNotVerified,
+		},
+		{
+			name:          "linked identity signs in without a verified email",
+			existingUsers: []*models.User{{Email: "ada@example.com"}},
+			identities:    []models.UserIdentity{{Provider: "test", Subject: "sub-1", Email: "ada@example.com", UserID: 1}},
+			claims: func(m *mockIssuer, nonce string) map[string]interface{} {
+				return m.idToken(nonce, "sub-1", "changed@example.com", false)
+			},
+			wantUserID: 1,
+			wantUsers:  1,
+			wantLinked: []models.UserIdentity{{Provider: "test", Subject: "sub-1", Email: "ada@example.com", UserID: 1}},
+		},
+		{
+			name: "nonce mismatch",
+			claims: func(m *mockIssuer, nonce string) map[string]interface{} {
+				return m.idToken("other-"+nonce, "sub-1", "ada@example.com", true)
+			},
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name: "missing nonce",
+			claims: func(m *mockIssuer, nonce string) map[string]interface{} {
+				return m.idToken("", "sub-1", "ada@example.com", true)
+			},
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name: "token for another client",
+			claims: func(m *mockIssuer, nonce string) map[string]interface{} {
+				claims := m.idToken(nonce, "sub-1", "ada@example.com", true)
+				claims["aud"] = "other-client"
+				return claims
+			},
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name: "token from another issuer",
+			claims: func(m *mockIssuer, nonce string) map[string]interface{} {
+				claims := m.idToken(nonce, "sub-1", "ada@example.com", true)
+				claims["iss"] = "https://issuer.example.com"
+				return claims
+			},
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name: "expired token",
+			claims: func(m *mockIssuer, nonce string) map[string]interface{} {
+				claims := m.idToken(nonce, "sub-1", "ada@example.com", true)
+				claims["exp"] = time.Now().Add(-time.Hour).Unix()
+				return claims
+			},
+			wantErr: ErrInvalidToken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			for _, u := range tt.existingUsers {
+				env.users.add(u)
+			}
+			for _, identity := range tt.identities {
+				env.repo.CreateIdentity(context.Background(), &identity)
+			}
+			env.issuer.setClaims(func(nonce string) map[string]interface{} { return tt.claims(env.issuer, nonce) })
+
+			_, err := env.service.Callback(context.Background(), "test", env.login(t, "test"))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Callback error = %v, want %v", err, tt.wantErr)
+			}
+
+			var wantSessions []uint
+			if tt.wantErr == nil {
+				wantSessions = []uint{tt.wantUserID}
+			}
+			if !equalIDs(env.sessions.sessions, wantSessions) {
+				t.Errorf("sessions created for users %v, want %v", env.sessions.sessions, wantSessions)
+			}
+			if len(env.users.users) != tt.wantUsers {
+				t.Errorf("%d users exist, want %d", len(env.users.users), tt.wantUsers)
+			}
+			if len(env.repo.identities) != len(tt.wantLinked) {
+				t.Fatalf("identities = %+v, want %+v", env.repo.identities, tt.wantLinked)
+			}
+			for n, want := range tt.wantLinked {
+				got := env.repo.identities[n]
+				if got.Provider != want.Provider || got.Subject != want.Subject || got.Email != want.Email || got.UserID != want.UserID {
+					t.Errorf("identity %d = %+v, want %+v", n, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCallbackState(t *testing.T) {
+	env := newTestEnv(t)
+	env.issuer.setClaims(func(nonce string) map[string]interface{} {
+		return env.issuer.idToken(nonce, "sub-1", "ada@example.com", true)
+	})
+	ctx := context.Background()
+
+	callback := env.login(t, "test")
+	if _, err := env.service.Callback(ctx, "test", callback); err != nil {
+		t.Fatalf("first Callback: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		provider string
+		callback Callback
+		wantErr  error
+	}{
+		{"reused state", "test", callback, ErrInvalidState},
+		{"unknown state", "test", Callback{Code: "code", State: "unknown"}, ErrInvalidState},
+		{"missing state", "test", Callback{Code: "code"}, ErrInvalidState},
+		{"state of another provider", "other", env.login(t, "test"), ErrInvalidState},
+		{"unknown provider", "missing", env.login(t, "test"), ErrUnknownProvider},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := env.service.Callback(ctx, tt.provider, tt.callback)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Callback error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if !equalIDs(env.sessions.sessions, []uint{1}) {
+		t.Errorf("sessions created for users %v, want only the first login", env.sessions.sessions)
+	}
+}
+
+func TestCallbackProviderError(t *testing.T) {
+	env := newTestEnv(t)
+	callback := env.login(t, "test")
+	callback.Error = "access_denied"
+
+	if _, err := env.service.Callback(context.Background(), "test", callback); err == nil {
+		t.Fatal("Callback succeeded after the provider returned an error")
+	}
+	if len(env.sessions.sessions) != 0 {
+		t.Errorf("sessions created for users %v, want none", env.sessions.sessions)
+	}
+}
+
+func equalIDs(a, b []uint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for n := range a {
+		if a[n] != b[n] {
+			return false
+		}
+	}
+	return true
+}
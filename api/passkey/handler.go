@@ -0,0 +1,130 @@
+package passkey
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.learning/api/auth"
+	"go.learning/middlewares"
+)
+
+type Handler interface {
+	BeginRegistration(c echo.Context) (err error)
+	FinishRegistration(c echo.Context) (err error)
+	GetList(c echo.Context) (err error)
+	Delete(c echo.Context) (err error)
+	BeginLogin(c echo.Context) (err error)
+	FinishLogin(c echo.Context) (err error)
+}
+
+type handler struct {
+	service Service
+}
+
+func NewHandler(service Service) Handler {
+	return handler{service}
+}
+
+func (h handler) BeginRegistration(c echo.Context) (err error) {
+	userID, ok := middlewares.CurrentUserID(c)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, "User is not authenticated")
+	}
+
+	options, err := h.service.BeginRegistration(c.Request().Context(), userID)
+	if err != nil {
+		return
+	}
+
+	return c.JSON(http.StatusOK, options)
+}
+
+func (h handler) FinishRegistration(c echo.Context) (err error) {
+	userID, ok := middlewares.CurrentUserID(c)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, "User is not authenticated")
+	}
+
+	// Only the query is bound; the body is the browser's credential and is parsed by the service
+	var req Finish
+	if err = (&echo.DefaultBinder{}).BindQueryParams(c, &req); err != nil {
+		return
+	}
+
+	passkey, err := h.service.FinishRegistration(c.Request().Context(), userID, req, c.Request().Body)
+	if errors.Is(err, ErrInvalidCeremony) || errors.Is(err, ErrVerificationFailed) {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return
+	}
+
+	return c.JSON(http.StatusCreated, passkey)
+}
+
+func (h handler) GetList(c echo.Context) (err error) {
+	userID, ok := middlewares.CurrentUserID(c)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, "User is not authenticated")
+	}
+
+	passkeys, err := h.service.GetPasskeyList(c.Request().Context(), userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "Failed to get passkey list")
+	}
+
+	return c.JSON(http.StatusOK, passkeys)
+}
+
+func (h handler) Delete(c echo.Context) (err error) {
+	userID, ok := middlewares.CurrentUserID(c)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, "User is not authenticated")
+	}
+
+	// Convert id to uint
+	var id uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &id); err != nil {
+		return c.JSON(http.StatusBadRequest, "Invalid ID format")
+	}
+
+	err = h.service.DeletePasskey(c.Request().Context(), userID, id)
+	if errors.Is(err, ErrCredentialNotFound) {
+		return c.JSON(http.StatusNotFound, "Passkey not found")
+	}
+	if err != nil {
+		return
+	}
+
+	return c.JSON(http.StatusNoContent, nil)
+}
+
+func (h handler) BeginLogin(c echo.Context) (err error) {
+	options, err := h.service.BeginLogin(c.Request().Context())
+	if err != nil {
+		return
+	}
+
+	return c.JSON(http.StatusOK, options)
+}
+
+func (h handler) FinishLogin(c echo.Context) (err error) {
+	var req Finish
+	if err = (&echo.DefaultBinder{}).BindQueryParams(c, &req); err != nil {
+		return
+	}
+
+	loginResponse, err := h.service.FinishLogin(c.Request().Context(), req, c.Request().Body)
+	switch {
+	case errors.Is(err, ErrInvalidCeremony), errors.Is(err, ErrVerificationFailed), errors.Is(err, ErrClonedAuthenticator):
+		return c.JSON(http.StatusUnauthorized, err.Error())
+	case errors.Is(err, auth.ErrUserInactive):
+		return c.JSON(http.StatusForbidden, err.Error())
+	case err != nil:
+		return
+	}
+
+	return c.JSON(http.StatusOK, loginResponse)
+}
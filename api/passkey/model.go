@@ -0,0 +1,90 @@
+package passkey
+
+import (
+	"encoding/binary"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"go.learning/models"
+)
+
+type RegistrationOptions struct {
+	SessionID string                       `json:"session_id"` // pass back as ?session_id= when finishing
+	Options   *protocol.CredentialCreation `json:"options"`
+}
+
+type LoginOptions struct {
+	SessionID string                        `json:"session_id"` // pass back as ?session_id= when finishing
+	Options   *protocol.CredentialAssertion `json:"options"`
+}
+
+// Finish identifies the ceremony; the body is the PublicKeyCredential returned by the browser
+type Finish struct {
+	SessionID string `query:"session_id"`
+	Name      string `query:"name"` // label for a new passkey, e.g. "MacBook"
+}
+
+type Passkey struct {
+	ID         uint     `json:"id"`
+	Name       string   `json:"name"`
+	Transports []string `json:"transports"`
+	Synced     bool     `json:"synced"`
+	LastUsedAt *string  `json:"last_used_at"`
+	CreatedAt  string   `json:"created_at"`
+}
+
+// passkeyUser adapts a user and their passkeys to the webauthn library
+type passkeyUser struct {
+	user        *models.User
+	credentials []models.WebAuthnCredential
+}
+
+// WebAuthnID is the user handle stored on the authenticator; it must not contain personal data
+func (u passkeyUser) WebAuthnID() []byte {
+	return userHandle(u.user.ID)
+}
+
+func (u passkeyUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u passkeyUser) WebAuthnDisplayName() string {
+	return u.user.FirstName + " " + u.user.LastName
+}
+
+func (u passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.credentials))
+	for _, c := range u.credentials {
+		transports := []protocol.AuthenticatorTransport{}
+		for _, transport := range c.TransportList() {
+			transports = append(transports, protocol.AuthenticatorTransport(transport))
+		}
+		credentials = append(credentials, webauthn.Credential{
+			ID:              c.CredentialID,
+			PublicKey:       c.PublicKey,
+			AttestationType: c.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: c.BackupEligible,
+				BackupState:    c.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    c.AAGUID,
+				SignCount: c.SignCount,
+			},
+		})
+	}
+	return credentials
+}
+
+func userHandle(userID uint) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(userID))
+}
+
+func userIDFromHandle(handle []byte) (uint, bool) {
+	if len(handle) != 8 {
+		return 0, false
+	}
+	id := binary.BigEndian.Uint64(handle)
+	return uint(id), id != 0
+}
@@ -0,0 +1,75 @@
+package passkey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.learning/models"
+	"gorm.io/gorm"
+)
+
+var ErrCredentialNotFound = errors.New("passkey not found")
+
+type Repository interface {
+	CreateCredential(ctx context.Context, credential *models.WebAuthnCredential) error
+	GetCredentialList(ctx context.Context, userID uint) ([]models.WebAuthnCredential, error)
+	UpdateCredentialUsage(ctx context.Context, credential *models.WebAuthnCredential) error
+	DeleteCredential(ctx context.Context, userID, id uint) error
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) *repository {
+	return &repository{db}
+}
+
+func (r *repository) CreateCredential(ctx context.Context, credential *models.WebAuthnCredential) error {
+	err := r.db.WithContext(ctx).Create(credential).Error
+	if err != nil {
+		return fmt.Errorf("failed to create passkey: %w", err)
+	}
+	return nil
+}
+
+func (r *repository) GetCredentialList(ctx context.Context, userID uint) ([]models.WebAuthnCredential, error) {
+	var credentials []models.WebAuthnCredential
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at ASC").Find(&credentials).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get passkey list: %w", err)
+	}
+	return credentials, nil
+}
+
+// UpdateCredentialUsage stores the sign counter and flags from a successful assertion.
+// The counter only moves forward so a concurrent, older assertion can't roll it back.
+func (r *repository) UpdateCredentialUsage(ctx context.Context, credential *models.WebAuthnCredential) error {
+	result := r.db.WithContext(ctx).Model(&models.WebAuthnCredential{}).
+		Where("id = ? AND (sign_count < ? OR sign_count = 0)", credential.ID, credential.SignCount).
+		Updates(map[string]interface{}{
+			"sign_count":   credential.SignCount,
+			"backup_state": credential.BackupState,
+			"last_used_at": time.Now(),
+		})
+	if result.Error != nil {
+		return fmt.Errorf("failed to update passkey: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrClonedAuthenticator
+	}
+	return nil
+}
+
+func (r *repository) DeleteCredential(ctx context.Context, userID, id uint) error {
+	result := r.db.WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).Delete(&models.WebAuthnCredential{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete passkey: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrCredentialNotFound
+	}
+	return nil
+}
@@ -0,0 +1,339 @@
+package passkey
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"go.learning/api/auth"
+	"go.learning/api/user"
+	"go.learning/config"
+	"go.learning/metrics"
+	"go.learning/models"
+	"go.learning/tracing"
+)
+
+const (
+	ceremonyKeyPrefix = "passkey:ceremony:"
+
+	// ceremonyTTL bounds how long the browser has to complete a ceremony
+	ceremonyTTL = 5 * time.Minute
+)
+
+var (
+	ErrInvalidCeremony     = errors.New("passkey ceremony is invalid or expired")
+	ErrVerificationFailed  = errors.New("passkey verification failed")
+	ErrClonedAuthenticator = errors.New("passkey sign counter went backwards; the authenticator may be cloned")
+)
+
+type Service interface {
+	BeginRegistration(ctx context.Context, userID uint) (*RegistrationOptions, error)
+	FinishRegistration(ctx context.Context, userID uint, req Finish, body io.Reader) (*Passkey, error)
+	GetPasskeyList(ctx context.Context, userID uint) ([]Passkey, error)
+	DeletePasskey(ctx context.Context, userID, id uint) error
+	BeginLogin(ctx context.Context) (*LoginOptions, error)
+	FinishLogin(ctx context.Context, req Finish, body io.Reader) (auth.LoginResponse, error)
+}
+
+type service struct {
+	repository     Repository
+	userRepository user.Repository
+	authService    auth.Service
+	redisClient    *redis.Client
+	webAuthn       *webauthn.WebAuthn
+}
+
+func NewService(repository Repository, userRepository user.Repository, authService auth.Service, redisClient *redis.Client, cfg config.WebAuthn) (Service, error) {
+	webAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          cfg.RPID,
+		RPDisplayName: cfg.RPDisplayName,
+		RPOrigins:     cfg.RPOrigins,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure WebAuthn: %w", err)
+	}
+
+	return &service{
+		repository:     repository,
+		userRepository: userRepository,
+		authService:    authService,
+		redisClient:    redisClient,
+		webAuthn:       webAuthn,
+	}, nil
+}
+
+// BeginRegistration returns the options for navigator.credentials.create
+func (s *service) BeginRegistration(ctx context.Context, userID uint) (*RegistrationOptions, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "passkey.Service/BeginRegistration")
+	defer span.End()
+
+	u, err := s.loadUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Exclude passkeys the user already has so the same authenticator isn't registered twice
+	exclusions := []protocol.CredentialDescriptor{}
+	for _, credential := range u.WebAuthnCredentials() {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+
+	creation, session, err := s.webAuthn.BeginRegistration(u,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin passkey registration: %w", err)
+	}
+
+	sessionID, err := s.storeCeremony(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+	return &RegistrationOptions{SessionID: sessionID, Options: creation}, nil
+}
+
+// FinishRegistration verifies the attestation and stores the new passkey
+func (s *service) FinishRegistration(ctx context.Context, userID uint, req Finish, body io.Reader) (*Passkey, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "passkey.Service/FinishRegistration")
+	defer span.End()
+
+	session, err := s.loadCeremony(ctx, req.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	u, err := s.loadUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(session.UserID, u.WebAuthnID()) {
+		return nil, ErrInvalidCeremony
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrVerificationFailed, err)
+	}
+	credential, err := s.webAuthn.CreateCredential(u, *session, parsed)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrVerificationFailed, err)
+	}
+
+	transports := []string{}
+	for _, transport := range credential.Transport {
+		transports = append(transports, string(transport))
+	}
+	stored := &models.WebAuthnCredential{
+		UserID:          userID,
+		Name:            strings.TrimSpace(req.Name),
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		Transports:      strings.Join(transports, " "),
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+	}
+	if stored.Name == "" {
+		stored.Name = "Passkey"
+	}
+	err = s.repository.CreateCredential(ctx, stored)
+	if err != nil {
+		return nil, err
+	}
+
+	passkey := toPasskey(*stored)
+	return &passkey, nil
+}
+
+func (s *service) GetPasskeyList(ctx context.Context, userID uint) ([]Passkey, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "passkey.Service/GetPasskeyList")
+	defer span.End()
+
+	credentials, err := s.repository.GetCredentialList(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	passkeyList := []Passkey{}
+	for _, credential := range credentials {
+		passkeyList = append(passkeyList, toPasskey(credential))
+	}
+	return passkeyList, nil
+}
+
+func (s *service) DeletePasskey(ctx context.Context, userID, id uint) error {
+	ctx, span := tracing.Tracer.Start(ctx, "passkey.Service/DeletePasskey")
+	defer span.End()
+
+	return s.repository.DeleteCredential(ctx, userID, id)
+}
+
+// BeginLogin returns the options for navigator.credentials.get. No user is named up front;
+// the authenticator offers its discoverable passkeys and reports which user was chosen.
+func (s *service) BeginLogin(ctx context.Context) (*LoginOptions, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "passkey.Service/BeginLogin")
+	defer span.End()
+
+	assertion, session, err := s.webAuthn.BeginDiscoverableLogin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin passkey login: %w", err)
+	}
+
+	sessionID, err := s.storeCeremony(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginOptions{SessionID: sessionID, Options: assertion}, nil
+}
+
+// FinishLogin verifies the assertion and creates a session exactly like a password login
+func (s *service) FinishLogin(ctx context.Context, req Finish, body io.Reader) (response auth.LoginResponse, err error) {
+	ctx, span := tracing.Tracer.Start(ctx, "passkey.Service/FinishLogin")
+	defer span.End()
+
+	// Record the outcome of the login attempt
+	defer func() {
+		if err != nil {
+			metrics.LoginAttemptsTotal.WithLabelValues(metrics.LoginFailure).Inc()
+			return
+		}
+		metrics.LoginAttemptsTotal.WithLabelValues(metrics.LoginSuccess).Inc()
+	}()
+
+	session, err := s.loadCeremony(ctx, req.SessionID)
+	if err != nil {
+		return auth.LoginResponse{}, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(body)
+	if err != nil {
+		return auth.LoginResponse{}, fmt.Errorf("%w: %v", ErrVerificationFailed, err)
+	}
+
+	// Resolve the user from the user handle the authenticator returned
+	var owner passkeyUser
+	handler := func(rawID, handle []byte) (webauthn.User, error) {
+		userID, ok := userIDFromHandle(handle)
+		if !ok {
+			return nil, ErrVerificationFailed
+		}
+		u, err := s.loadUser(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		owner = u
+		return u, nil
+	}
+	_, credential, err := s.webAuthn.ValidatePasskeyLogin(handler, *session, parsed)
+	if err != nil {
+		return auth.LoginResponse{}, fmt.Errorf("%w: %v", ErrVerificationFailed, err)
+	}
+
+	// A counter that didn't increase means two copies of the private key may exist
+	if credential.Authenticator.CloneWarning {
+		return auth.LoginResponse{}, ErrClonedAuthenticator
+	}
+
+	stored, ok := owner.credential(credential.ID)
+	if !ok {
+		return auth.LoginResponse{}, ErrVerificationFailed
+	}
+	stored.SignCount = credential.Authenticator.SignCount
+	stored.BackupState = credential.Flags.BackupState
+	err = s.repository.UpdateCredentialUsage(ctx, stored)
+	if err != nil {
+		return auth.LoginResponse{}, err
+	}
+
+	return s.authService.CreateSession(ctx, owner.user.ID)
+}
+
+func (s *service) loadUser(ctx context.Context, userID uint) (passkeyUser, error) {
+	u, err := s.userRepository.GetUserByID(ctx, userID)
+	if errors.Is(err, user.ErrUserNotFound) {
+		return passkeyUser{}, ErrVerificationFailed
+	}
+	if err != nil {
+		return passkeyUser{}, err
+	}
+	credentials, err := s.repository.GetCredentialList(ctx, userID)
+	if err != nil {
+		return passkeyUser{}, err
+	}
+	return passkeyUser{user: u, credentials: credentials}, nil
+}
+
+// storeCeremony keeps the challenge in Redis until the browser finishes the ceremony
+func (s *service) storeCeremony(ctx context.Context, session *webauthn.SessionData) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random value: %w", err)
+	}
+	sessionID := base64.RawURLEncoding.EncodeToString(b)
+
+	payload, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+	err = s.redisClient.Set(ctx, ceremonyKeyPrefix+sessionID, payload, ceremonyTTL).Err()
+	if err != nil {
+		return "", fmt.Errorf("failed to store passkey ceremony: %w", err)
+	}
+	return sessionID, nil
+}
+
+// loadCeremony consumes a ceremony so its challenge can't be answered twice
+func (s *service) loadCeremony(ctx context.Context, sessionID string) (*webauthn.SessionData, error) {
+	if sessionID == "" {
+		return nil, ErrInvalidCeremony
+	}
+	payload, err := s.redisClient.GetDel(ctx, ceremonyKeyPrefix+sessionID).Bytes()
+	if err == redis.Nil {
+		return nil, ErrInvalidCeremony
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load passkey ceremony: %w", err)
+	}
+	var session webauthn.SessionData
+	if err := json.Unmarshal(payload, &session); err != nil {
+		return nil, ErrInvalidCeremony
+	}
+	return &session, nil
+}
+
+func (u passkeyUser) credential(id []byte) (*models.WebAuthnCredential, bool) {
+	for i := range u.credentials {
+		if bytes.Equal(u.credentials[i].CredentialID, id) {
+			return &u.credentials[i], true
+		}
+	}
+	return nil, false
+}
+
+func toPasskey(credential models.WebAuthnCredential) Passkey {
+	var lastUsedAt *string
+	if credential.LastUsedAt != nil {
+		formatted := credential.LastUsedAt.Format("2006-01-02 15:04:05")
+		lastUsedAt = &formatted
+	}
+	return Passkey{
+		ID:         credential.ID,
+		Name:       credential.Name,
+		Transports: credential.TransportList(),
+		Synced:     credential.BackupState,
+		LastUsedAt: lastUsedAt,
+		CreatedAt:  credential.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+}
@@ -1,26 +1,44 @@
 package user
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/labstack/echo/v4"
+	"go.learning/api/apikey"
+	"go.learning/middlewares"
+	"go.learning/password"
 )
 
 type Handler interface {
 	Register(c echo.Context) (err error)
 	GetList(c echo.Context) (err error)
+	Export(c echo.Context) (err error)
 	Get(c echo.Context) (err error)
 	Update(c echo.Context) (err error)
+	Patch(c echo.Context) (err error)
 	Delete(c echo.Context) (err error)
+	BulkUpdate(c echo.Context) (err error)
+	BulkDelete(c echo.Context) (err error)
+	Restore(c echo.Context) (err error)
+	Erase(c echo.Context) (err error)
+	ChangePassword(c echo.Context) (err error)
+	Import(c echo.Context) (err error)
 }
 
+// maxImportSize bounds the body of an import request; larger files go through the import command
+const maxImportSize = 10 << 20
+
 type handler struct {
-	service Service
+	service  Service
+	importer Importer
 }
 
-func NewHandler(service Service) Handler {
-	return handler{service}
+func NewHandler(service Service, importer Importer) Handler {
+	return handler{service, importer}
 }
 
 func (h handler) Register(c echo.Context) (err error) {
@@ -29,7 +47,11 @@ func (h handler) Register(c echo.Context) (err error) {
 		return
 	}
 
-	err = h.service.CreateUser(req)
+	err = h.service.CreateUser(c.Request().Context(), req)
+	var weakErr *password.WeakPasswordError
+	if errors.As(err, &weakErr) {
+		return c.JSON(http.StatusBadRequest, weakErr)
+	}
 	if err != nil {
 		return
 	}
@@ -62,7 +84,7 @@ func (h handler) GetList(c echo.Context) (err error) {
 	}
 
 	// Call the service to get the user list
-	users, err := h.service.GetUserList(queryParams)
+	users, err := h.service.GetUserList(c.Request().Context(), queryParams)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, "Failed to get user list")
 	}
@@ -70,6 +92,44 @@ func (h handler) GetList(c echo.Context) (err error) {
 	return c.JSON(http.StatusOK, users)
 }
 
+// Export streams the whole filtered user list as a file download
+func (h handler) Export(c echo.Context) (err error) {
+	var req ExportUserList
+	if err = c.Bind(&req); err != nil {
+		return
+	}
+
+	if req.Format == "" {
+		req.Format = "csv"
+	}
+	format, ok := ExportFormats[req.Format]
+	if !ok {
+		return c.JSON(http.StatusBadRequest, ErrUnknownExportFormat.Error())
+	}
+
+	columns, err := ParseExportColumns(req.Columns)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if req.Sort == "" {
+		req.Sort = "first_name"
+	}
+	if !IsExportColumn(req.Sort) {
+		return c.JSON(http.StatusBadRequest, "Invalid sort column")
+	}
+	if req.SortDirection != "" && req.SortDirection != "asc" && req.SortDirection != "desc" {
+		return c.JSON(http.StatusBadRequest, "sort_direction must be asc or desc")
+	}
+
+	// Headers go out before the first row, so later errors can only cut the download short
+	c.Response().Header().Set(echo.HeaderContentType, format.ContentType)
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="users.%s"`, format.Extension))
+	c.Response().WriteHeader(http.StatusOK)
+
+	return h.service.ExportUserList(c.Request().Context(), req.GetUserList, columns, req.Format, c.Response())
+}
+
 func (h handler) Get(c echo.Context) (err error) {
 	id := c.Param("id")
 	if id == "" {
@@ -82,11 +142,12 @@ func (h handler) Get(c echo.Context) (err error) {
 		return c.JSON(http.StatusBadRequest, "Invalid ID format")
 	}
 
-	user, err := h.service.GetUserByID(userID)
+	user, err := h.service.GetUserByID(c.Request().Context(), userID)
 	if err != nil {
 		return c.JSON(http.StatusNotFound, "User not found")
 	}
 
+	c.Response().Header().Set("ETag", etag(user.Version))
 	return c.JSON(http.StatusOK, user)
 }
 
@@ -96,14 +157,106 @@ func (h handler) Update(c echo.Context) (err error) {
 		return
 	}
 
-	err = h.service.UpdateUser(req)
-	if err != nil {
+	// Only update the version the client last read, when it says which one that was
+	var ok bool
+	if req.Version, ok = ifMatchVersion(c); !ok {
+		return c.JSON(http.StatusPreconditionFailed, "If-Match does not name a current version")
+	}
+
+	version, err := h.service.UpdateUser(c.Request().Context(), req, currentCaller(c))
+	switch {
+	case errors.Is(err, ErrInvalidPatch):
+		return c.JSON(http.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrFieldForbidden):
+		return c.JSON(http.StatusForbidden, err.Error())
+	case errors.Is(err, ErrUserNotFound):
+		return c.JSON(http.StatusNotFound, "User not found")
+	case errors.Is(err, ErrVersionConflict):
+		return c.JSON(http.StatusPreconditionFailed, err.Error())
+	case err != nil:
 		return
 	}
 
+	c.Response().Header().Set("ETag", etag(version))
 	return c.JSON(http.StatusOK, nil)
 }
 
+// Patch applies a JSON Merge Patch, so fields missing from the body keep their value
+func (h handler) Patch(c echo.Context) (err error) {
+	id := c.Param("id")
+	if id == "" {
+		return c.JSON(http.StatusBadRequest, "ID is required")
+	}
+
+	// Convert id to uint
+	var userID uint
+	if _, err := fmt.Sscanf(id, "%d", &userID); err != nil {
+		return c.JSON(http.StatusBadRequest, "Invalid ID format")
+	}
+
+	mediaType, _, _ := strings.Cut(c.Request().Header.Get(echo.HeaderContentType), ";")
+	if mediaType = strings.TrimSpace(mediaType); mediaType != MergePatchContentType && mediaType != echo.MIMEApplicationJSON {
+		return c.JSON(http.StatusUnsupportedMediaType, "Content-Type must be "+MergePatchContentType)
+	}
+
+	patch, err := parseMergePatch(c.Request().Body)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	patch.ID = userID
+
+	var ok bool
+	if patch.Version, ok = ifMatchVersion(c); !ok {
+		return c.JSON(http.StatusPreconditionFailed, "If-Match does not name a current version")
+	}
+
+	user, err := h.service.PatchUser(c.Request().Context(), patch, currentCaller(c))
+	switch {
+	case errors.Is(err, ErrInvalidPatch):
+		return c.JSON(http.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrFieldForbidden):
+		return c.JSON(http.StatusForbidden, err.Error())
+	case errors.Is(err, ErrUserNotFound):
+		return c.JSON(http.StatusNotFound, "User not found")
+	case errors.Is(err, ErrVersionConflict):
+		return c.JSON(http.StatusPreconditionFailed, err.Error())
+	case err != nil:
+		return
+	}
+
+	c.Response().Header().Set("ETag", etag(user.Version))
+	return c.JSON(http.StatusOK, user)
+}
+
+// currentCaller describes the authenticated user behind the request for permission checks
+func currentCaller(c echo.Context) Caller {
+	callerID, _ := middlewares.CurrentUserID(c)
+	return Caller{UserID: callerID, AdminScope: middlewares.HasScope(c, apikey.ScopeUsersAdmin)}
+}
+
+// etag formats a user version as a strong entity tag
+func etag(version uint) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// ifMatchVersion returns the version named by If-Match, or 0 when the header is absent or "*".
+// It is not ok when the header can't match any version, e.g. a weak or malformed tag.
+func ifMatchVersion(c echo.Context) (uint, bool) {
+	header := strings.TrimSpace(c.Request().Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, true
+	}
+	unquoted, err := strconv.Unquote(header)
+	if err != nil || !strings.HasPrefix(header, `"`) {
+		return 0, false
+	}
+	version, err := strconv.ParseUint(unquoted, 10, 0)
+	if err != nil || version == 0 {
+		return 0, false
+	}
+	return uint(version), true
+}
+
 func (h handler) Delete(c echo.Context) (err error) {
 	id := c.Param("id")
 	if id == "" {
@@ -116,10 +269,154 @@ func (h handler) Delete(c echo.Context) (err error) {
 		return c.JSON(http.StatusBadRequest, "Invalid ID format")
 	}
 
-	err = h.service.DeleteUser(userID)
+	err = h.service.DeleteUser(c.Request().Context(), userID)
+	if errors.Is(err, ErrUserNotFound) {
+		return c.JSON(http.StatusNotFound, "User not found")
+	}
 	if err != nil {
+		return
+	}
+
+	return c.JSON(http.StatusNoContent, nil)
+}
+
+func (h handler) BulkUpdate(c echo.Context) (err error) {
+	var req BulkUpdateUsers
+	if err = c.Bind(&req); err != nil {
+		return
+	}
+
+	result, err := h.service.BulkUpdateUsers(c.Request().Context(), req)
+	if errors.Is(err, ErrInvalidSelection) || errors.Is(err, ErrNothingToUpdate) {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+func (h handler) BulkDelete(c echo.Context) (err error) {
+	var req UserSelection
+	if err = c.Bind(&req); err != nil {
+		return
+	}
+
+	result, err := h.service.BulkDeleteUsers(c.Request().Context(), req)
+	if errors.Is(err, ErrInvalidSelection) {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+func (h handler) Restore(c echo.Context) (err error) {
+	id := c.Param("id")
+	if id == "" {
+		return c.JSON(http.StatusBadRequest, "ID is required")
+	}
+
+	// Convert id to uint
+	var userID uint
+	if _, err := fmt.Sscanf(id, "%d", &userID); err != nil {
+		return c.JSON(http.StatusBadRequest, "Invalid ID format")
+	}
+
+	err = h.service.RestoreUser(c.Request().Context(), userID)
+	switch {
+	case errors.Is(err, ErrUserNotFound):
+		return c.JSON(http.StatusNotFound, "Deleted user not found")
+	case errors.Is(err, ErrEmailTaken):
+		return c.JSON(http.StatusConflict, err.Error())
+	case err != nil:
+		return
+	}
+
+	return c.JSON(http.StatusNoContent, nil)
+}
+
+func (h handler) Erase(c echo.Context) (err error) {
+	id := c.Param("id")
+	if id == "" {
+		return c.JSON(http.StatusBadRequest, "ID is required")
+	}
+
+	// Convert id to uint
+	var userID uint
+	if _, err := fmt.Sscanf(id, "%d", &userID); err != nil {
+		return c.JSON(http.StatusBadRequest, "Invalid ID format")
+	}
+
+	err = h.service.EraseUser(c.Request().Context(), userID)
+	if errors.Is(err, ErrUserNotFound) {
 		return c.JSON(http.StatusNotFound, "User not found")
 	}
+	if err != nil {
+		return
+	}
+
+	return c.JSON(http.StatusNoContent, nil)
+}
+
+func (h handler) ChangePassword(c echo.Context) (err error) {
+	userID, ok := middlewares.CurrentUserID(c)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, "User is not authenticated")
+	}
+
+	var req ChangePassword
+	if err = c.Bind(&req); err != nil {
+		return
+	}
+
+	err = h.service.ChangePassword(c.Request().Context(), userID, req)
+	var weakErr *password.WeakPasswordError
+	switch {
+	case errors.Is(err, ErrInvalidPassword), errors.Is(err, ErrPasswordReused):
+		return c.JSON(http.StatusBadRequest, err.Error())
+	case errors.As(err, &weakErr):
+		return c.JSON(http.StatusBadRequest, weakErr)
+	case err != nil:
+		return
+	}
 
 	return c.JSON(http.StatusNoContent, nil)
 }
+
+// Import creates or updates users from a CSV or NDJSON body. The format comes from ?format= or the Content-Type.
+func (h handler) Import(c echo.Context) (err error) {
+	format := c.QueryParam("format")
+	if format == "" {
+		switch mediaType, _, _ := strings.Cut(c.Request().Header.Get(echo.HeaderContentType), ";"); strings.TrimSpace(mediaType) {
+		case "text/csv":
+			format = FormatCSV
+		case "application/x-ndjson", "application/jsonl":
+			format = FormatNDJSON
+		}
+	}
+
+	dryRun := false
+	if value := c.QueryParam("dry_run"); value != "" {
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			return c.JSON(http.StatusBadRequest, "dry_run must be true or false")
+		}
+	}
+
+	body := http.MaxBytesReader(c.Response(), c.Request().Body, maxImportSize)
+	report, err := h.importer.Import(c.Request().Context(), format, body, dryRun)
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		return c.JSON(http.StatusRequestEntityTooLarge, "Import file is too large")
+	case errors.Is(err, ErrUnknownFormat), errors.Is(err, ErrInvalidFile):
+		return c.JSON(http.StatusBadRequest, err.Error())
+	case err != nil:
+		return
+	}
+
+	return c.JSON(http.StatusOK, report)
+}
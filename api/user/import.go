@@ -0,0 +1,309 @@
+package user
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/gommon/log"
+	"go.learning/models"
+	"go.learning/password"
+	"go.learning/tracing"
+)
+
+// Import file formats
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// importBatchSize is how many rows are written per transaction
+const importBatchSize = 500
+
+// maxImportLine bounds a single NDJSON line
+const maxImportLine = 64 * 1024
+
+var (
+	ErrUnknownFormat = errors.New("format must be csv or ndjson")
+	ErrInvalidFile   = errors.New("invalid import file")
+)
+
+// csvColumns are the columns a CSV import may have; email is required
+var csvColumns = map[string]bool{"email": true, "first_name": true, "last_name": true, "password": true, "active": true}
+
+// Importer creates and updates users in bulk from CSV or NDJSON files
+type Importer interface {
+	Import(ctx context.Context, format string, r io.Reader, dryRun bool) (*ImportReport, error)
+}
+
+type importer struct {
+	repository Repository
+	hasher     password.Hasher
+	policy     password.Policy
+	sessions   SessionRevoker
+}
+
+func NewImporter(repository Repository, hasher password.Hasher, policy password.Policy, sessions SessionRevoker) Importer {
+	return importer{repository, hasher, policy, sessions}
+}
+
+// importRow is a parsed row with the line it came from
+type importRow struct {
+	line      int
+	user      ImportUser
+	errors    []string
+	malformed bool // the row could not be decoded, so there is nothing to validate
+}
+
+// Import validates every row, then upserts the valid ones by email in batches. Rows that fail validation
+// are skipped; a batch that can't be written is rolled back as a whole. Dry runs roll back every batch
+// and skip password hashing.
+func (i importer) Import(ctx context.Context, format string, r io.Reader, dryRun bool) (*ImportReport, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "user.Importer/Import")
+	defer span.End()
+
+	var rows []importRow
+	var err error
+	switch format {
+	case FormatCSV:
+		rows, err = parseCSV(r)
+	case FormatNDJSON:
+		rows, err = parseNDJSON(r)
+	default:
+		return nil, ErrUnknownFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ImportReport{DryRun: dryRun, Total: len(rows), Rows: make([]ImportRowResult, len(rows))}
+
+	// Validate every row before writing anything
+	seen := make(map[string]int)
+	var valid []int
+	for n := range rows {
+		row := &rows[n]
+		row.user.Email = strings.TrimSpace(row.user.Email)
+		row.user.FirstName = strings.TrimSpace(row.user.FirstName)
+		row.user.LastName = strings.TrimSpace(row.user.LastName)
+		if !row.malformed {
+			row.errors = append(row.errors, i.validate(ctx, row.user)...)
+		}
+		if first, ok := seen[strings.ToLower(row.user.Email)]; ok && row.user.Email != "" {
+			row.errors = append(row.errors, fmt.Sprintf("email already appears on line %d", first))
+		} else {
+			seen[strings.ToLower(row.user.Email)] = row.line
+		}
+
+		report.Rows[n] = ImportRowResult{Line: row.line, Email: row.user.Email, Errors: row.errors}
+		if len(row.errors) > 0 {
+			report.Rows[n].Status = ImportInvalid
+			report.Invalid++
+			continue
+		}
+		valid = append(valid, n)
+	}
+
+	for start := 0; start < len(valid); start += importBatchSize {
+		end := start + importBatchSize
+		if end > len(valid) {
+			end = len(valid)
+		}
+		batch := valid[start:end]
+
+		users, err := i.buildUsers(rows, batch, dryRun)
+		if err != nil {
+			return nil, err
+		}
+
+		created, err := i.repository.UpsertUsers(ctx, users, dryRun)
+		for k, n := range batch {
+			switch {
+			case err != nil:
+				report.Rows[n].Status = ImportFailed
+				report.Rows[n].Errors = []string{err.Error()}
+				report.Failed++
+			case created[k]:
+				report.Rows[n].Status = ImportCreated
+				report.Created++
+			default:
+				report.Rows[n].Status = ImportUpdated
+				report.Updated++
+			}
+		}
+
+		// Users the import deactivated must not stay logged in
+		if err == nil && !dryRun {
+			for k, u := range users {
+				if !created[k] && u.SetActive && !u.Active {
+					if err := i.sessions.RevokeUserSessions(ctx, u.ID); err != nil {
+						log.Errorf("failed to revoke sessions of user %d: %v", u.ID, err)
+					}
+				}
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// validate returns every problem with the row
+func (i importer) validate(ctx context.Context, u ImportUser) []string {
+	var problems []string
+	if u.Email == "" {
+		problems = append(problems, "email is required")
+	} else if addr, err := mail.ParseAddress(u.Email); err != nil || addr.Address != u.Email {
+		problems = append(problems, "email is not a valid address")
+	} else if len(u.Email) > 255 {
+		problems = append(problems, "email is longer than 255 characters")
+	}
+	if u.FirstName == "" {
+		problems = append(problems, "first_name is required")
+	} else if len(u.FirstName) > 100 {
+		problems = append(problems, "first_name is longer than 100 characters")
+	}
+	if u.LastName == "" {
+		problems = append(problems, "last_name is required")
+	} else if len(u.LastName) > 100 {
+		problems = append(problems, "last_name is longer than 100 characters")
+	}
+
+	if u.Password != "" {
+		err := i.policy.Check(ctx, u.Password, u.Email, u.FirstName, u.LastName)
+		var weakErr *password.WeakPasswordError
+		if errors.As(err, &weakErr) {
+			for _, reason := range weakErr.Reasons {
+				problems = append(problems, "password "+reason)
+			}
+		} else if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	return problems
+}
+
+// buildUsers converts the batch to models, hashing passwords unless this is a dry run.
+// New users are active unless the row says otherwise; existing ones only change when it does.
+func (i importer) buildUsers(rows []importRow, batch []int, dryRun bool) ([]UpsertUser, error) {
+	users := make([]UpsertUser, 0, len(batch))
+	for _, n := range batch {
+		row := rows[n].user
+		u := &models.User{
+			Email:     row.Email,
+			FirstName: row.FirstName,
+			LastName:  row.LastName,
+			Active:    row.Active == nil || *row.Active,
+		}
+		if row.Password != "" && !dryRun {
+			hashedPassword, err := i.hasher.Hash(row.Password)
+			if err != nil {
+				return nil, err
+			}
+			now := time.Now()
+			u.HashedPassword = hashedPassword
+			u.PasswordChangedAt = &now
+		}
+		users = append(users, UpsertUser{User: u, SetActive: row.Active != nil})
+	}
+	return users, nil
+}
+
+// parseCSV reads a CSV file whose header names the columns
+func parseCSV(r io.Reader) ([]importRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: missing header row", ErrInvalidFile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidFile, err)
+	}
+	columns := make(map[string]int, len(header))
+	for n, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !csvColumns[name] {
+			return nil, fmt.Errorf("%w: unknown column %q", ErrInvalidFile, name)
+		}
+		columns[name] = n
+	}
+	if _, ok := columns["email"]; !ok {
+		return nil, fmt.Errorf("%w: missing email column", ErrInvalidFile)
+	}
+
+	var rows []importRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidFile, err)
+		}
+		line, _ := reader.FieldPos(0)
+
+		row := importRow{line: line}
+		if len(record) != len(header) {
+			row.errors = append(row.errors, fmt.Sprintf("expected %d fields, got %d", len(header), len(record)))
+		}
+		field := func(name string) string {
+			if n, ok := columns[name]; ok && n < len(record) {
+				return record[n]
+			}
+			return ""
+		}
+		row.user = ImportUser{
+			Email:     field("email"),
+			FirstName: field("first_name"),
+			LastName:  field("last_name"),
+			Password:  field("password"),
+		}
+		if value := strings.TrimSpace(field("active")); value != "" {
+			active, err := strconv.ParseBool(value)
+			if err != nil {
+				row.errors = append(row.errors, "active must be true or false")
+			}
+			row.user.Active = &active
+		}
+		rows = append(rows, row)
+	}
+}
+
+// parseNDJSON reads one JSON object per line; blank lines are skipped
+func parseNDJSON(r io.Reader) ([]importRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxImportLine)
+
+	var rows []importRow
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		row := importRow{line: line}
+		decoder := json.NewDecoder(strings.NewReader(text))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&row.user); err != nil {
+			row.errors = append(row.errors, "invalid JSON: "+err.Error())
+			row.malformed = true
+		}
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: line %d: %w", ErrInvalidFile, line+1, err)
+	}
+	return rows, nil
+}
@@ -0,0 +1,233 @@
+package user
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"go.learning/models"
+	"go.learning/tracing"
+	"go.learning/xlsx"
+)
+
+// exportFlushEvery is how many rows are written between flushes to the client
+const exportFlushEvery = 500
+
+var (
+	ErrUnknownExportFormat = errors.New("format must be csv, ndjson or xlsx")
+	ErrUnknownColumn       = errors.New("unknown column")
+)
+
+// ExportFormat describes how an exported list is served
+type ExportFormat struct {
+	ContentType string
+	Extension   string
+}
+
+// ExportFormats are the supported list export formats, keyed by the format query parameter
+var ExportFormats = map[string]ExportFormat{
+	"csv":    {"text/csv; charset=utf-8", "csv"},
+	"ndjson": {"application/x-ndjson", "ndjson"},
+	"xlsx":   {xlsx.ContentType, "xlsx"},
+}
+
+// exportColumns are the user fields that can be exported, in their default order
+var exportColumns = []string{"id", "email", "first_name", "last_name", "active", "role", "created_at", "updated_at"}
+
+// ParseExportColumns validates a comma separated column list; empty selects every column
+func ParseExportColumns(list string) ([]string, error) {
+	if strings.TrimSpace(list) == "" {
+		return exportColumns, nil
+	}
+	var columns []string
+	for _, column := range strings.Split(list, ",") {
+		column = strings.TrimSpace(column)
+		if !IsExportColumn(column) {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownColumn, column)
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+// IsExportColumn reports whether column can be exported and sorted on
+func IsExportColumn(column string) bool {
+	for _, c := range exportColumns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+func exportValue(u models.User, column string) interface{} {
+	switch column {
+	case "id":
+		return u.ID
+	case "email":
+		return u.Email
+	case "first_name":
+		return u.FirstName
+	case "last_name":
+		return u.LastName
+	case "active":
+		return u.Active
+	case "role":
+		return u.Role
+	case "created_at":
+		return u.CreatedAt.Format("2006-01-02 15:04:05")
+	case "updated_at":
+		return u.UpdatedAt.Format("2006-01-02 15:04:05")
+	}
+	return nil
+}
+
+// rowWriter encodes exported users in one format
+type rowWriter interface {
+	WriteUser(u models.User) error
+	Flush() error
+	Close() error
+}
+
+func newRowWriter(format string, w io.Writer, columns []string) (rowWriter, error) {
+	switch format {
+	case "csv":
+		writer := csv.NewWriter(w)
+		if err := writer.Write(columns); err != nil {
+			return nil, err
+		}
+		return &csvRowWriter{writer, columns}, nil
+	case "ndjson":
+		return &ndjsonRowWriter{json.NewEncoder(w), columns}, nil
+	case "xlsx":
+		writer, err := xlsx.NewWriter(w, "Users")
+		if err != nil {
+			return nil, err
+		}
+		if err := writer.WriteRow(columns); err != nil {
+			return nil, err
+		}
+		return &xlsxRowWriter{writer, columns}, nil
+	}
+	return nil, ErrUnknownExportFormat
+}
+
+func exportTexts(u models.User, columns []string) []string {
+	texts := make([]string, len(columns))
+	for n, column := range columns {
+		texts[n] = fmt.Sprint(exportValue(u, column))
+	}
+	return texts
+}
+
+type csvRowWriter struct {
+	writer  *csv.Writer
+	columns []string
+}
+
+func (w *csvRowWriter) WriteUser(u models.User) error {
+	texts := exportTexts(u, w.columns)
+	// Spreadsheets open CSV cells starting with these as formulas; user supplied names must stay text
+	for n, text := range texts {
+		if text != "" && strings.ContainsRune("=+-@\t\r", rune(text[0])) {
+			texts[n] = "'" + text
+		}
+	}
+	return w.writer.Write(texts)
+}
+
+func (w *csvRowWriter) Flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+func (w *csvRowWriter) Close() error {
+	return w.Flush()
+}
+
+type ndjsonRowWriter struct {
+	encoder *json.Encoder
+	columns []string
+}
+
+func (w *ndjsonRowWriter) WriteUser(u models.User) error {
+	object := make(map[string]interface{}, len(w.columns))
+	for _, column := range w.columns {
+		object[column] = exportValue(u, column)
+	}
+	return w.encoder.Encode(object)
+}
+
+func (w *ndjsonRowWriter) Flush() error { return nil }
+
+func (w *ndjsonRowWriter) Close() error { return nil }
+
+type xlsxRowWriter struct {
+	writer  *xlsx.Writer
+	columns []string
+}
+
+func (w *xlsxRowWriter) WriteUser(u models.User) error {
+	return w.writer.WriteRow(exportTexts(u, w.columns))
+}
+
+func (w *xlsxRowWriter) Flush() error {
+	return w.writer.Flush()
+}
+
+func (w *xlsxRowWriter) Close() error {
+	return w.writer.Close()
+}
+
+// ExportUserList writes every user matching the filters to w as they are read from the database.
+// Columns and the sort column must have been validated with ParseExportColumns and IsExportColumn.
+func (s service) ExportUserList(ctx context.Context, queryParams GetUserList, columns []string, format string, w io.Writer) error {
+	ctx, span := tracing.Tracer.Start(ctx, "user.Service/ExportUserList")
+	defer span.End()
+
+	buffered := bufio.NewWriter(w)
+	rows, err := newRowWriter(format, buffered, columns)
+	if err != nil {
+		return err
+	}
+
+	// Push rows out regularly so the client sees progress and nothing piles up in buffers
+	flush := func() error {
+		if err := rows.Flush(); err != nil {
+			return err
+		}
+		if err := buffered.Flush(); err != nil {
+			return err
+		}
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	written := 0
+	err = s.Repository.StreamUsers(ctx, queryParams, columns, func(u models.User) error {
+		if err := rows.WriteUser(u); err != nil {
+			return err
+		}
+		written++
+		if written%exportFlushEvery == 0 {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	return buffered.Flush()
+}
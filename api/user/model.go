@@ -9,7 +9,7 @@ type Pagination struct {
 
 type GetUserList struct {
 	Pagination
-	FirstName *string `query:"first_name"`
+	FirstName *string `query:"first_name" json:"first_name"`
 }
 
 type PaginationResponse struct {
@@ -28,11 +28,33 @@ type CreateUser struct {
 	Password  string `json:"password"`
 }
 
+type ChangePassword struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
 type UpdateUser struct {
 	ID        uint   `json:"id"`
 	FirstName string `json:"first_name"`
 	LastName  string `json:"last_name"`
 	Active    bool   `json:"active"`
+	Version   uint   `json:"-"` // expected version from If-Match, 0 to update unconditionally
+}
+
+// PatchUser holds the fields present in a merge patch; nil fields are left as they are
+type PatchUser struct {
+	ID        uint
+	FirstName *string
+	LastName  *string
+	Active    *bool
+	Role      *string
+	Version   uint // expected version from If-Match, 0 to patch unconditionally
+}
+
+// Caller is the authenticated user behind a request
+type Caller struct {
+	UserID     uint
+	AdminScope bool // false for API keys without the users:admin scope, which can't act as admin even for admins
 }
 
 type User struct {
@@ -41,6 +63,65 @@ type User struct {
 	FirstName string `json:"first_name"`
 	LastName  string `json:"last_name"`
 	Active    bool   `json:"active"`
+	Role      string `json:"role"`
 	CreatedAt string `json:"created_at"`
 	UpdatedAt string `json:"updated_at"`
+	Version   uint   `json:"-"` // sent as the ETag
+}
+
+// ImportUser is one row of a bulk import. A password is optional; users without one can log in after a password reset.
+type ImportUser struct {
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Password  string `json:"password"`
+	Active    *bool  `json:"active"` // defaults to true
+}
+
+// Import row outcomes
+const (
+	ImportCreated = "created"
+	ImportUpdated = "updated"
+	ImportInvalid = "invalid" // the row failed validation and was skipped
+	ImportFailed  = "failed"  // the row's batch could not be written
+)
+
+type ImportRowResult struct {
+	Line   int      `json:"line"`
+	Email  string   `json:"email"`
+	Status string   `json:"status"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+type ImportReport struct {
+	DryRun  bool              `json:"dry_run"`
+	Total   int               `json:"total"`
+	Created int               `json:"created"`
+	Updated int               `json:"updated"`
+	Invalid int               `json:"invalid"`
+	Failed  int               `json:"failed"`
+	Rows    []ImportRowResult `json:"rows"`
+}
+
+// ExportUserList streams every user matching the GetUserList filters; pagination is ignored
+type ExportUserList struct {
+	GetUserList
+	Format  string `query:"format"`  // csv, ndjson or xlsx
+	Columns string `query:"columns"` // comma separated, all columns by default
+}
+
+// UserSelection picks the users of a bulk operation, either by ID or with the GetUserList filters
+type UserSelection struct {
+	IDs    []uint       `json:"ids"`
+	Filter *GetUserList `json:"filter"`
+}
+
+type BulkUpdateUsers struct {
+	UserSelection
+	Active *bool `json:"active"`
+}
+
+type BulkResult struct {
+	Affected int `json:"affected"`
+	NotFound int `json:"not_found"` // requested IDs that don't exist or were already deleted
 }
@@ -0,0 +1,101 @@
+package user
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"go.learning/models"
+)
+
+// MergePatchContentType is the media type of JSON Merge Patch documents (RFC 7396)
+const MergePatchContentType = "application/merge-patch+json"
+
+var (
+	ErrInvalidPatch   = errors.New("invalid merge patch")
+	ErrFieldForbidden = errors.New("not allowed to change field")
+)
+
+// parseMergePatch reads a merge patch document. Every member must be a patchable field; null is rejected
+// since none of them can be removed.
+func parseMergePatch(r io.Reader) (PatchUser, error) {
+	var patch PatchUser
+	var members map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&members); err != nil {
+		return patch, fmt.Errorf("%w: body must be a JSON object", ErrInvalidPatch)
+	}
+	if members == nil {
+		return patch, fmt.Errorf("%w: body must be a JSON object", ErrInvalidPatch)
+	}
+
+	for name, raw := range members {
+		var target interface{}
+		switch name {
+		case "first_name":
+			target = &patch.FirstName
+		case "last_name":
+			target = &patch.LastName
+		case "active":
+			target = &patch.Active
+		case "role":
+			target = &patch.Role
+		default:
+			return patch, fmt.Errorf("%w: field %q can't be patched", ErrInvalidPatch, name)
+		}
+		if bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+			return patch, fmt.Errorf("%w: field %q can't be null", ErrInvalidPatch, name)
+		}
+		if err := json.Unmarshal(raw, target); err != nil {
+			return patch, fmt.Errorf("%w: field %q has the wrong type", ErrInvalidPatch, name)
+		}
+	}
+	return patch, nil
+}
+
+// updates validates the present fields and returns them as columns
+func (p PatchUser) updates() (map[string]interface{}, error) {
+	updates := make(map[string]interface{})
+	for _, field := range []struct {
+		column string
+		value  *string
+	}{{"first_name", p.FirstName}, {"last_name", p.LastName}} {
+		if field.value == nil {
+			continue
+		}
+		value := strings.TrimSpace(*field.value)
+		if value == "" || len(value) > 100 {
+			return nil, fmt.Errorf("%w: %s must be 1 to 100 characters", ErrInvalidPatch, field.column)
+		}
+		updates[field.column] = value
+	}
+	if p.Active != nil {
+		updates["active"] = *p.Active
+	}
+	if p.Role != nil {
+		if *p.Role != models.RoleUser && *p.Role != models.RoleAdmin {
+			return nil, fmt.Errorf("%w: role must be %s or %s", ErrInvalidPatch, models.RoleUser, models.RoleAdmin)
+		}
+		updates["role"] = *p.Role
+	}
+	return updates, nil
+}
+
+// checkPermissions lets users change their own name; everything else, and other users' names, needs an admin
+func (p PatchUser) checkPermissions(caller Caller, admin bool) error {
+	if admin {
+		return nil
+	}
+	if p.Active != nil {
+		return fmt.Errorf("%w: active", ErrFieldForbidden)
+	}
+	if p.Role != nil {
+		return fmt.Errorf("%w: role", ErrFieldForbidden)
+	}
+	if caller.UserID != p.ID && (p.FirstName != nil || p.LastName != nil) {
+		return fmt.Errorf("%w: name of another user", ErrFieldForbidden)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package user
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.learning/models"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestParseMergePatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    PatchUser
+		wantErr error
+	}{
+		{"empty object", `{}`, PatchUser{}, nil},
+		{"names", `{"first_name":"Ada","last_name":"Lovelace"}`, PatchUser{FirstName: ptr("Ada"), LastName: ptr("Lovelace")}, nil},
+		{"active false", `{"active":false}`, PatchUser{Active: ptr(false)}, nil},
+		{"role", `{"role":"admin"}`, PatchUser{Role: ptr("admin")}, nil},
+		{"every field", `{"first_name":"A","last_name":"B","active":true,"role":"user"}`,
+			PatchUser{FirstName: ptr("A"), LastName: ptr("B"), Active: ptr(true), Role: ptr("user")}, nil},
+		{"unknown field", `{"email":"a@example.com"}`, PatchUser{}, ErrInvalidPatch},
+		{"read-only field", `{"id":2}`, PatchUser{}, ErrInvalidPatch},
+		{"null field", `{"first_name":null}`, PatchUser{}, ErrInvalidPatch},
+		{"wrong type", `{"active":"yes"}`, PatchUser{}, ErrInvalidPatch},
+		{"name as number", `{"last_name":1}`, PatchUser{}, ErrInvalidPatch},
+		{"array body", `[{"first_name":"A"}]`, PatchUser{}, ErrInvalidPatch},
+		{"null body", `null`, PatchUser{}, ErrInvalidPatch},
+		{"empty body", ``, PatchUser{}, ErrInvalidPatch},
+		{"malformed body", `{"first_name":`, PatchUser{}, ErrInvalidPatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMergePatch(strings.NewReader(tt.body))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("parseMergePatch error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseMergePatch = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPatchUserUpdates(t *testing.T) {
+	tests := []struct {
+		name    string
+		patch   PatchUser
+		want    map[string]interface{}
+		wantErr error
+	}{
+		{"nothing", PatchUser{}, map[string]interface{}{}, nil},
+		{"trimmed name", PatchUser{FirstName: ptr("  Ada ")}, map[string]interface{}{"first_name": "Ada"}, nil},
+		{"active and role", PatchUser{Active: ptr(false), Role: ptr(models.RoleAdmin)},
+			map[string]interface{}{"active": false, "role": models.RoleAdmin}, nil},
+		{"blank name", PatchUser{LastName: ptr("   ")}, nil, ErrInvalidPatch},
+		{"long name", PatchUser{FirstName: ptr(strings.Repeat("a", 101))}, nil, ErrInvalidPatch},
+		{"unknown role", PatchUser{Role: ptr("owner")}, nil, ErrInvalidPatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.patch.updates()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("updates error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("updates = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPatchUserCheckPermissions(t *testing.T) {
+	self := Caller{UserID: 1}
+	tests := []struct {
+		name    string
+		patch   PatchUser
+		caller  Caller
+		admin   bool
+		wantErr error
+	}{
+		{"own name", PatchUser{ID: 1, FirstName: ptr("A"), LastName: ptr("B")}, self, false, nil},
+		{"empty patch of another user", PatchUser{ID: 2}, self, false, nil},
+		{"name of another user", PatchUser{ID: 2, LastName: ptr("B")}, self, false, ErrFieldForbidden},
+		{"own active flag", PatchUser{ID: 1, Active: ptr(true)}, self, false, ErrFieldForbidden},
+		{"own role", PatchUser{ID: 1, Role: ptr(models.RoleAdmin)}, self, false, ErrFieldForbidden},
+		{"admin changes another user", PatchUser{ID: 2, FirstName: ptr("A"), Active: ptr(false), Role: ptr(models.RoleAdmin)},
+			Caller{UserID: 1, AdminScope: true}, true, nil},
+		{"admin changes own role", PatchUser{ID: 1, Role: ptr(models.RoleUser)}, Caller{UserID: 1, AdminScope: true}, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.patch.checkPermissions(tt.caller, tt.admin); !errors.Is(err, tt.wantErr) {
+				t.Errorf("checkPermissions error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -2,20 +2,47 @@ package user
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	"go.learning/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+var (
+	ErrUserNotFound    = errors.New("user not found")
+	ErrEmailTaken      = errors.New("email is already used by another user")
+	ErrVersionConflict = errors.New("user was changed by someone else")
+
+	// errDryRun rolls back a transaction whose changes were only meant to be reported
+	errDryRun = errors.New("dry run")
+)
+
+// uniqueViolation is the Postgres error code for a unique index conflict
+const uniqueViolation = "23505"
+
 type Repository interface {
-	CreateUser(user *models.User) error
-	GetUserList(queryParams GetUserList) ([]models.User, int64, error)
-	GetUserByID(id uint) (*models.User, error)
-	GetUserByEmail(email string) (*models.User, error)
-	UpdateUser(user *models.User) error
-	DeleteUser(id uint) error
+	CreateUser(ctx context.Context, user *models.User) error
+	GetUserList(ctx context.Context, queryParams GetUserList) ([]models.User, int64, error)
+	StreamUsers(ctx context.Context, queryParams GetUserList, columns []string, fn func(models.User) error) error
+	GetUserByID(ctx context.Context, id uint) (*models.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	UpdateUser(ctx context.Context, user *models.User) error
+	PatchUser(ctx context.Context, user *models.User, updates map[string]interface{}) error
+	UpdateUserPassword(ctx context.Context, id uint, hashedPassword string) error
+	ChangeUserPassword(ctx context.Context, id uint, hashedPassword string, historySize int) error
+	GetPasswordHistory(ctx context.Context, id uint, limit int) ([]string, error)
+	DeleteUser(ctx context.Context, id uint) error
+	BulkUpdateUsers(ctx context.Context, selection UserSelection, updates map[string]interface{}) ([]uint, error)
+	BulkDeleteUsers(ctx context.Context, selection UserSelection) ([]uint, error)
+	RestoreUser(ctx context.Context, id uint) error
+	EraseUser(ctx context.Context, id uint) error
+	GetErasableUserIDs(ctx context.Context, deletedBefore time.Time, limit int) ([]uint, error)
+	UpsertUsers(ctx context.Context, users []UpsertUser, dryRun bool) ([]bool, error)
 }
 
 type repository struct {
@@ -26,20 +53,17 @@ func NewRepository(db *gorm.DB) *repository {
 	return &repository{db}
 }
 
-func (r *repository) CreateUser(user *models.User) error {
-	err := r.db.WithContext(context.Background()).Create(user).Error
+func (r *repository) CreateUser(ctx context.Context, user *models.User) error {
+	err := r.db.WithContext(ctx).Create(user).Error
 	if err != nil {
 		return fmt.Errorf("failed to create user: %w", err)
 	}
 	return nil
 }
 
-func (r *repository) GetUserList(queryParams GetUserList) ([]models.User, int64, error) {
+func (r *repository) GetUserList(ctx context.Context, queryParams GetUserList) ([]models.User, int64, error) {
 	var users []models.User
-	query := r.db.WithContext(context.Background()).Where("deleted_at IS NULL")
-	if queryParams.FirstName != nil {
-		query = query.Where("first_name ILIKE ?", "%"+*queryParams.FirstName+"%")
-	}
+	query := filterUsers(r.db.WithContext(ctx), queryParams)
 
 	// Count total records
 	var total int64
@@ -71,54 +95,347 @@ func (r *repository) GetUserList(queryParams GetUserList) ([]models.User, int64,
 	return users, total, nil
 }
 
-func (r *repository) GetUserByID(id uint) (*models.User, error) {
+// filterUsers applies the GetUserList filters, leaving out deleted users
+func filterUsers(query *gorm.DB, queryParams GetUserList) *gorm.DB {
+	query = query.Where("deleted_at IS NULL")
+	if queryParams.FirstName != nil {
+		query = query.Where("first_name ILIKE ?", "%"+*queryParams.FirstName+"%")
+	}
+	return query
+}
+
+// exportFetchSize is how many rows each FETCH from the export cursor returns
+const exportFetchSize = 1000
+
+// StreamUsers walks the filtered user list through a server-side cursor, loading only columns, and calls fn
+// for each user in order. The caller must pass a known column as the sort.
+func (r *repository) StreamUsers(ctx context.Context, queryParams GetUserList, columns []string, fn func(models.User) error) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		query := filterUsers(tx.Model(&models.User{}).Select(columns), queryParams).
+			Order(clause.OrderByColumn{Column: clause.Column{Name: queryParams.Sort}, Desc: queryParams.SortDirection == "desc"}).
+			Order("id ASC")
+		stmt := query.Session(&gorm.Session{DryRun: true}).Find(&[]models.User{}).Statement
+
+		_, err := tx.Statement.ConnPool.ExecContext(ctx, "DECLARE user_export NO SCROLL CURSOR FOR "+stmt.SQL.String(), stmt.Vars...)
+		if err != nil {
+			return fmt.Errorf("failed to open user cursor: %w", err)
+		}
+
+		for {
+			var users []models.User
+			err := tx.Raw(fmt.Sprintf("FETCH FORWARD %d FROM user_export", exportFetchSize)).Scan(&users).Error
+			if err != nil {
+				return fmt.Errorf("failed to fetch users: %w", err)
+			}
+			for _, user := range users {
+				if err := fn(user); err != nil {
+					return err
+				}
+			}
+			if len(users) < exportFetchSize {
+				return nil
+			}
+		}
+	}, &sql.TxOptions{ReadOnly: true})
+}
+
+func (r *repository) GetUserByID(ctx context.Context, id uint) (*models.User, error) {
 	var user models.User
-	err := r.db.Where("deleted_at IS NULL").First(&user, id).Error
+	err := r.db.WithContext(ctx).Where("deleted_at IS NULL").First(&user, id).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("failed to get user by ID: %w", err)
 	}
 	return &user, nil
 }
 
-func (r *repository) GetUserByEmail(email string) (*models.User, error) {
+func (r *repository) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
 	var user models.User
-	err := r.db.Where("email = ? AND deleted_at IS NULL", email).First(&user).Error
+	err := r.db.WithContext(ctx).Where("email = ? AND deleted_at IS NULL", email).First(&user).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("failed to get user by email: %w", err)
 	}
 	return &user, nil
 }
 
-func (r *repository) UpdateUser(user *models.User) error {
-	err := r.db.WithContext(context.Background()).Model(user).Updates(map[string]interface{}{
+// UpdateUser overwrites the profile fields. A non-zero user.Version must match the stored version.
+// On success user.Version holds the new version.
+func (r *repository) UpdateUser(ctx context.Context, user *models.User) error {
+	return r.updateVersioned(ctx, user, map[string]interface{}{
 		"first_name": user.FirstName,
 		"last_name":  user.LastName,
 		"active":     user.Active,
-	}).Error
+	})
+}
+
+// PatchUser changes only the given columns, with the same version check as UpdateUser.
+// On success user holds the whole updated row.
+func (r *repository) PatchUser(ctx context.Context, user *models.User, updates map[string]interface{}) error {
+	return r.updateVersioned(ctx, user, updates)
+}
+
+// updateVersioned writes updates and bumps the version, reading the updated row back into user
+func (r *repository) updateVersioned(ctx context.Context, user *models.User, updates map[string]interface{}) error {
+	query := r.db.WithContext(ctx).Model(user).Clauses(clause.Returning{}).Where("id = ?", user.ID)
+	if user.Version > 0 {
+		query = query.Where("version = ?", user.Version)
+	}
+	updates["version"] = gorm.Expr("version + 1")
+	result := query.Updates(updates)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update user: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		return nil
+	}
+
+	// Tell a missing user apart from a stale version
+	if user.Version == 0 {
+		return ErrUserNotFound
+	}
+	_, err := r.GetUserByID(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	return ErrVersionConflict
+}
+
+func (r *repository) UpdateUserPassword(ctx context.Context, id uint, hashedPassword string) error {
+	err := r.db.WithContext(ctx).Model(&models.User{}).Where("id = ?", id).Update("hashed_password", hashedPassword).Error
 	if err != nil {
-		return fmt.Errorf("failed to update user: %w", err)
+		return fmt.Errorf("failed to update user password: %w", err)
 	}
 	return nil
 }
 
-func (r *repository) DeleteUser(id uint) error {
-	user, err := r.GetUserByID(id)
+// ChangeUserPassword sets a new password chosen by the user, moving the old hash into the history
+// and keeping only the newest historySize entries
+func (r *repository) ChangeUserPassword(ctx context.Context, id uint, hashedPassword string, historySize int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Select("id", "hashed_password").Where("deleted_at IS NULL").First(&user, id).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return ErrUserNotFound
+			}
+			return fmt.Errorf("failed to change user password: %w", err)
+		}
+
+		if user.HashedPassword != "" && historySize > 0 {
+			history := &models.PasswordHistory{UserID: id, HashedPassword: user.HashedPassword}
+			if err := tx.Create(history).Error; err != nil {
+				return fmt.Errorf("failed to record password history: %w", err)
+			}
+		}
+		err := tx.Where("user_id = ? AND id NOT IN (?)", id,
+			tx.Model(&models.PasswordHistory{}).Select("id").Where("user_id = ?", id).Order("id DESC").Limit(historySize),
+		).Delete(&models.PasswordHistory{}).Error
+		if err != nil {
+			return fmt.Errorf("failed to prune password history: %w", err)
+		}
+
+		err = tx.Model(&models.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"hashed_password":     hashedPassword,
+			"password_changed_at": time.Now(),
+		}).Error
+		if err != nil {
+			return fmt.Errorf("failed to change user password: %w", err)
+		}
+		return nil
+	})
+}
+
+// GetPasswordHistory returns the user's previous password hashes, newest first
+func (r *repository) GetPasswordHistory(ctx context.Context, id uint, limit int) ([]string, error) {
+	var hashes []string
+	err := r.db.WithContext(ctx).Model(&models.PasswordHistory{}).
+		Where("user_id = ?", id).Order("id DESC").Limit(limit).
+		Pluck("hashed_password", &hashes).Error
 	if err != nil {
-		return fmt.Errorf("failed to delete user: %w", err)
+		return nil, fmt.Errorf("failed to get password history: %w", err)
 	}
+	return hashes, nil
+}
 
-	user.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+// DeleteUser soft deletes the user; only deleted_at is written
+func (r *repository) DeleteUser(ctx context.Context, id uint) error {
+	result := r.db.WithContext(ctx).Where("id = ?", id).Delete(&models.User{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete user: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// selectUsers narrows query to the users of a bulk operation
+func selectUsers(query *gorm.DB, selection UserSelection) *gorm.DB {
+	if selection.Filter != nil {
+		return filterUsers(query, *selection.Filter)
+	}
+	return query.Where("deleted_at IS NULL AND id IN ?", selection.IDs)
+}
+
+// returningID makes bulk statements report the users they changed
+var returningID = clause.Returning{Columns: []clause.Column{{Name: "id"}}}
+
+// BulkUpdateUsers applies updates to every selected user in a single statement and returns their IDs
+func (r *repository) BulkUpdateUsers(ctx context.Context, selection UserSelection, updates map[string]interface{}) ([]uint, error) {
+	updates["version"] = gorm.Expr("version + 1")
+
+	var users []models.User
+	err := selectUsers(r.db.WithContext(ctx).Model(&users).Clauses(returningID), selection).Updates(updates).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to update users: %w", err)
+	}
+	return userIDs(users), nil
+}
 
-	err = r.db.Save(user).Error
+// BulkDeleteUsers soft deletes every selected user in a single statement and returns their IDs
+func (r *repository) BulkDeleteUsers(ctx context.Context, selection UserSelection) ([]uint, error) {
+	var users []models.User
+	err := selectUsers(r.db.WithContext(ctx).Clauses(returningID), selection).Delete(&users).Error
 	if err != nil {
-		return fmt.Errorf("failed to delete user: %w", err)
+		return nil, fmt.Errorf("failed to delete users: %w", err)
 	}
+	return userIDs(users), nil
+}
 
+func userIDs(users []models.User) []uint {
+	ids := make([]uint, len(users))
+	for n, user := range users {
+		ids[n] = user.ID
+	}
+	return ids
+}
+
+// RestoreUser undoes a soft delete unless the user was already anonymized
+func (r *repository) RestoreUser(ctx context.Context, id uint) error {
+	result := r.db.WithContext(ctx).Unscoped().Model(&models.User{}).
+		Where("id = ? AND deleted_at IS NOT NULL AND anonymized_at IS NULL", id).
+		Update("deleted_at", nil)
+	if result.Error != nil {
+		// Someone registered with the same email after the user was deleted
+		var pgErr *pgconn.PgError
+		if errors.As(result.Error, &pgErr) && pgErr.Code == uniqueViolation {
+			return ErrEmailTaken
+		}
+		return fmt.Errorf("failed to restore user: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrUserNotFound
+	}
 	return nil
 }
+
+// EraseUser scrubs the user's personal data and removes their credentials and linked accounts.
+// The anonymized row stays, soft deleted, so records that reference the user ID remain valid.
+func (r *repository) EraseUser(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Unscoped().Model(&models.User{}).Where("id = ? AND anonymized_at IS NULL", id).Updates(map[string]interface{}{
+			"email":               fmt.Sprintf("erased-%d@invalid", id),
+			"first_name":          "",
+			"last_name":           "",
+			"hashed_password":     "",
+			"password_changed_at": nil,
+			"active":              false,
+			"deleted_at":          gorm.Expr("COALESCE(deleted_at, ?)", now),
+			"anonymized_at":       now,
+		})
+		if result.Error != nil {
+			return fmt.Errorf("failed to erase user: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrUserNotFound
+		}
+
+		// Request logs hold the user's IP addresses and user agents; audit events hold nothing personal and are kept
+		for _, model := range []interface{}{&models.PasswordHistory{}, &models.UserIdentity{}, &models.WebAuthnCredential{}, &models.APIKey{}, &models.Logger{}} {
+			if err := tx.Where("user_id = ?", id).Delete(model).Error; err != nil {
+				return fmt.Errorf("failed to erase user data: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// GetErasableUserIDs returns users soft deleted before deletedBefore that still hold personal data
+func (r *repository) GetErasableUserIDs(ctx context.Context, deletedBefore time.Time, limit int) ([]uint, error) {
+	var ids []uint
+	err := r.db.WithContext(ctx).Unscoped().Model(&models.User{}).
+		Where("deleted_at < ? AND anonymized_at IS NULL", deletedBefore).
+		Order("id ASC").Limit(limit).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get erasable users: %w", err)
+	}
+	return ids, nil
+}
+
+// UpsertUser is a user written by an import. Without SetActive an existing user keeps their active flag.
+type UpsertUser struct {
+	*models.User
+	SetActive bool
+}
+
+// UpsertUsers creates users or updates the existing user with the same email, all in one transaction.
+// A non-empty HashedPassword replaces the current password. It reports which users were created.
+// With dryRun the transaction is rolled back after all statements ran.
+func (r *repository) UpsertUsers(ctx context.Context, users []UpsertUser, dryRun bool) ([]bool, error) {
+	created := make([]bool, len(users))
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for i, upsert := range users {
+			user := upsert.User
+			var existing models.User
+			err := tx.Select("id").Where("email = ? AND deleted_at IS NULL", user.Email).
+				Clauses(clause.Locking{Strength: "UPDATE"}).Take(&existing).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				if err := tx.Create(user).Error; err != nil {
+					return fmt.Errorf("failed to create user %s: %w", user.Email, err)
+				}
+				// The active column defaults to true, so Create skips a false value
+				if !user.Active {
+					if err := tx.Model(user).Update("active", false).Error; err != nil {
+						return fmt.Errorf("failed to create user %s: %w", user.Email, err)
+					}
+				}
+				created[i] = true
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to get user %s: %w", user.Email, err)
+			}
+
+			updates := map[string]interface{}{
+				"first_name": user.FirstName,
+				"last_name":  user.LastName,
+				"version":    gorm.Expr("version + 1"),
+			}
+			if upsert.SetActive {
+				updates["active"] = user.Active
+			}
+			if user.HashedPassword != "" {
+				updates["hashed_password"] = user.HashedPassword
+				updates["password_changed_at"] = user.PasswordChangedAt
+			}
+			if err := tx.Model(&models.User{}).Where("id = ?", existing.ID).Updates(updates).Error; err != nil {
+				return fmt.Errorf("failed to update user %s: %w", user.Email, err)
+			}
+			user.ID = existing.ID
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errDryRun) {
+		return nil, err
+	}
+	return created, nil
+}
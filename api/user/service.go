@@ -1,29 +1,87 @@
 package user
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/labstack/gommon/log"
+	"go.learning/config"
 	"go.learning/models"
-	"go.learning/utils"
+	"go.learning/password"
+	"go.learning/tracing"
+)
+
+var (
+	ErrInvalidPassword  = errors.New("current password is incorrect")
+	ErrPasswordReused   = errors.New("new password must differ from recently used passwords")
+	ErrInvalidSelection = fmt.Errorf("give either ids, at most %d, or a filter", maxBulkIDs)
+	ErrNothingToUpdate  = errors.New("no field to update")
 )
 
+// maxBulkIDs bounds the ID list of a bulk operation; larger sets are selected with a filter
+const maxBulkIDs = 1000
+
+// maxUpdateAttempts bounds how often an unconditional update is retried after a concurrent change
+const maxUpdateAttempts = 3
+
+// purgeBatchSize is how many expired users one purge query picks up
+const purgeBatchSize = 100
+
+// SessionRevoker ends the sessions of a user whose account goes away
+type SessionRevoker interface {
+	RevokeUserSessions(ctx context.Context, userID uint) error
+}
+
+// ArchiveDeleter drops a cached data export, which must not outlive an erasure
+type ArchiveDeleter interface {
+	DeleteArchive(ctx context.Context, userID uint) error
+}
+
+// AuditRecorder stores audit events of a user's account
+type AuditRecorder interface {
+	Record(ctx context.Context, userID uint, action string)
+}
+
 type service struct {
 	Repository
+	hasher   password.Hasher
+	policy   password.Policy
+	config   *config.Watcher
+	sessions SessionRevoker
+	archives ArchiveDeleter
+	audit    AuditRecorder
 }
 
 type Service interface {
-	GetUserList(queryParams GetUserList) (*GetUserListResponse, error)
-	GetUserByID(id uint) (*User, error)
-	CreateUser(user CreateUser) error
-	UpdateUser(user UpdateUser) error
-	DeleteUser(id uint) error
+	GetUserList(ctx context.Context, queryParams GetUserList) (*GetUserListResponse, error)
+	GetUserByID(ctx context.Context, id uint) (*User, error)
+	ExportUserList(ctx context.Context, queryParams GetUserList, columns []string, format string, w io.Writer) error
+	CreateUser(ctx context.Context, user CreateUser) error
+	UpdateUser(ctx context.Context, user UpdateUser, caller Caller) (uint, error)
+	PatchUser(ctx context.Context, patch PatchUser, caller Caller) (*User, error)
+	DeleteUser(ctx context.Context, id uint) error
+	BulkUpdateUsers(ctx context.Context, req BulkUpdateUsers) (*BulkResult, error)
+	BulkDeleteUsers(ctx context.Context, selection UserSelection) (*BulkResult, error)
+	RestoreUser(ctx context.Context, id uint) error
+	EraseUser(ctx context.Context, id uint) error
+	PurgeDeletedUsers(ctx context.Context) (int, error)
+	ChangePassword(ctx context.Context, id uint, req ChangePassword) error
+	IsAdmin(ctx context.Context, id uint) (bool, error)
 }
 
-func NewService(repository Repository) Service {
-	return service{repository}
+func NewService(repository Repository, hasher password.Hasher, policy password.Policy, config *config.Watcher, sessions SessionRevoker, archives ArchiveDeleter, audit AuditRecorder) Service {
+	return service{repository, hasher, policy, config, sessions, archives, audit}
 }
 
-func (s service) GetUserList(queryParams GetUserList) (*GetUserListResponse, error) {
+func (s service) GetUserList(ctx context.Context, queryParams GetUserList) (*GetUserListResponse, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "user.Service/GetUserList")
+	defer span.End()
+
 	// Call the repository to get the user list
-	users, total, err := s.Repository.GetUserList(queryParams)
+	users, total, err := s.Repository.GetUserList(ctx, queryParams)
 	if err != nil {
 		return nil, err
 	}
@@ -36,6 +94,7 @@ func (s service) GetUserList(queryParams GetUserList) (*GetUserListResponse, err
 			FirstName: user.FirstName,
 			LastName:  user.LastName,
 			Active:    user.Active,
+			Role:      user.Role,
 			CreatedAt: user.CreatedAt.Format("2006-01-02 15:04:05"),
 			UpdatedAt: user.UpdatedAt.Format("2006-01-02 15:04:05"),
 		})
@@ -52,25 +111,35 @@ func (s service) GetUserList(queryParams GetUserList) (*GetUserListResponse, err
 	return response, nil
 }
 
-func (s service) CreateUser(user CreateUser) error {
+func (s service) CreateUser(ctx context.Context, user CreateUser) error {
+	ctx, span := tracing.Tracer.Start(ctx, "user.Service/CreateUser")
+	defer span.End()
+
+	// Reject weak and breached passwords
+	err := s.policy.Check(ctx, user.Password, user.Email, user.FirstName, user.LastName)
+	if err != nil {
+		return err
+	}
 
 	// Generate a hashed password
-	hashedPassword, err := utils.GenerateHashedPassword(user.Password)
+	hashedPassword, err := s.hasher.Hash(user.Password)
 	if err != nil {
 		return err
 	}
 
 	// Convert user to models.User
+	now := time.Now()
 	newUser := &models.User{
-		Email:          user.Email,
-		FirstName:      user.FirstName,
-		LastName:       user.LastName,
-		HashedPassword: hashedPassword,
-		Active:         true,
+		Email:             user.Email,
+		FirstName:         user.FirstName,
+		LastName:          user.LastName,
+		HashedPassword:    hashedPassword,
+		PasswordChangedAt: &now,
+		Active:            true,
 	}
 
 	// Call the repository to create the user
-	err = s.Repository.CreateUser(newUser)
+	err = s.Repository.CreateUser(ctx, newUser)
 	if err != nil {
 		return err
 	}
@@ -78,9 +147,12 @@ func (s service) CreateUser(user CreateUser) error {
 	return nil
 }
 
-func (s service) GetUserByID(id uint) (*User, error) {
+func (s service) GetUserByID(ctx context.Context, id uint) (*User, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "user.Service/GetUserByID")
+	defer span.End()
+
 	// Call the repository to get the user by ID
-	user, err := s.Repository.GetUserByID(id)
+	user, err := s.Repository.GetUserByID(ctx, id)
 	if err != nil {
 		return nil, err
 	}
@@ -92,35 +164,338 @@ func (s service) GetUserByID(id uint) (*User, error) {
 		FirstName: user.FirstName,
 		LastName:  user.LastName,
 		Active:    user.Active,
+		Role:      user.Role,
 		CreatedAt: user.CreatedAt.Format("2006-01-02 15:04:05"),
 		UpdatedAt: user.UpdatedAt.Format("2006-01-02 15:04:05"),
+		Version:   user.Version,
 	}, nil
 }
 
-func (s service) UpdateUser(user UpdateUser) error {
-	// Convert user to models.User
-	updatedUser := &models.User{
+// UpdateUser replaces the user's profile and returns the new version. It takes the same permission checks as
+// a patch; active is only written, and only needs an admin, when it differs from the stored value.
+func (s service) UpdateUser(ctx context.Context, user UpdateUser, caller Caller) (uint, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "user.Service/UpdateUser")
+	defer span.End()
+
+	for attempt := 1; ; attempt++ {
+		current, err := s.Repository.GetUserByID(ctx, user.ID)
+		if err != nil {
+			return 0, err
+		}
+		if user.Version > 0 && current.Version != user.Version {
+			return 0, ErrVersionConflict
+		}
+
+		// Only update the row active was compared against
+		patch := PatchUser{ID: user.ID, FirstName: &user.FirstName, LastName: &user.LastName, Version: current.Version}
+		if user.Active != current.Active {
+			patch.Active = &user.Active
+		}
+
+		updated, err := s.PatchUser(ctx, patch, caller)
+		// Without If-Match the client didn't ask for a precondition, so a concurrent change is read again
+		if errors.Is(err, ErrVersionConflict) && user.Version == 0 && attempt < maxUpdateAttempts {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		return updated.Version, nil
+	}
+}
+
+// PatchUser changes only the fields present in the patch, after checking the caller may change each of them
+func (s service) PatchUser(ctx context.Context, patch PatchUser, caller Caller) (*User, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "user.Service/PatchUser")
+	defer span.End()
+
+	updates, err := patch.updates()
+	if err != nil {
+		return nil, err
+	}
+
+	admin, err := s.callerIsAdmin(ctx, caller)
+	if err != nil {
+		return nil, err
+	}
+	if err := patch.checkPermissions(caller, admin); err != nil {
+		return nil, err
+	}
+
+	var user *models.User
+	if len(updates) == 0 {
+		// An empty patch changes nothing, but the precondition still applies
+		user, err = s.Repository.GetUserByID(ctx, patch.ID)
+		if err == nil && patch.Version > 0 && user.Version != patch.Version {
+			err = ErrVersionConflict
+		}
+	} else {
+		user = &models.User{ID: patch.ID, Version: patch.Version}
+		err = s.Repository.PatchUser(ctx, user, updates)
+		if err == nil {
+			s.audit.Record(ctx, user.ID, models.AuditProfileUpdated)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Deactivated users must not stay logged in
+	if patch.Active != nil && !*patch.Active {
+		if err := s.sessions.RevokeUserSessions(ctx, user.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	return &User{
 		ID:        user.ID,
+		Email:     user.Email,
 		FirstName: user.FirstName,
 		LastName:  user.LastName,
 		Active:    user.Active,
-	}
+		Role:      user.Role,
+		CreatedAt: user.CreatedAt.Format("2006-01-02 15:04:05"),
+		UpdatedAt: user.UpdatedAt.Format("2006-01-02 15:04:05"),
+		Version:   user.Version,
+	}, nil
+}
+
+func (s service) DeleteUser(ctx context.Context, id uint) error {
+	ctx, span := tracing.Tracer.Start(ctx, "user.Service/DeleteUser")
+	defer span.End()
 
-	// Call the repository to update the user
-	err := s.Repository.UpdateUser(updatedUser)
+	// Call the repository to delete the user
+	err := s.Repository.DeleteUser(ctx, id)
 	if err != nil {
 		return err
 	}
+	s.audit.Record(ctx, id, models.AuditUserDeleted)
+
+	// A deleted user must not stay logged in
+	return s.sessions.RevokeUserSessions(ctx, id)
+}
+
+func (s service) BulkUpdateUsers(ctx context.Context, req BulkUpdateUsers) (*BulkResult, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "user.Service/BulkUpdateUsers")
+	defer span.End()
+
+	if err := validateSelection(req.UserSelection); err != nil {
+		return nil, err
+	}
+	if req.Active == nil {
+		return nil, ErrNothingToUpdate
+	}
+
+	ids, err := s.Repository.BulkUpdateUsers(ctx, req.UserSelection, map[string]interface{}{"active": *req.Active})
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		s.audit.Record(ctx, id, models.AuditProfileUpdated)
+	}
+
+	// Deactivated users must not stay logged in
+	if !*req.Active {
+		s.revokeSessions(ctx, ids)
+	}
+
+	return bulkResult(req.UserSelection, ids), nil
+}
+
+func (s service) BulkDeleteUsers(ctx context.Context, selection UserSelection) (*BulkResult, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "user.Service/BulkDeleteUsers")
+	defer span.End()
+
+	if err := validateSelection(selection); err != nil {
+		return nil, err
+	}
+
+	ids, err := s.Repository.BulkDeleteUsers(ctx, selection)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		s.audit.Record(ctx, id, models.AuditUserDeleted)
+	}
+
+	s.revokeSessions(ctx, ids)
 
+	return bulkResult(selection, ids), nil
+}
+
+// validateSelection requires exactly one of an ID list or a filter
+func validateSelection(selection UserSelection) error {
+	if (len(selection.IDs) > 0) == (selection.Filter != nil) || len(selection.IDs) > maxBulkIDs {
+		return ErrInvalidSelection
+	}
 	return nil
 }
 
-func (s service) DeleteUser(id uint) error {
-	// Call the repository to delete the user
-	err := s.Repository.DeleteUser(id)
+// revokeSessions ends the sessions of users changed by a committed bulk operation. A failure for one user
+// doesn't stop the others, since the change itself can't be undone anymore.
+func (s service) revokeSessions(ctx context.Context, ids []uint) {
+	for _, id := range ids {
+		if err := s.sessions.RevokeUserSessions(ctx, id); err != nil {
+			log.Errorf("failed to revoke sessions of user %d: %v", id, err)
+		}
+	}
+}
+
+func bulkResult(selection UserSelection, ids []uint) *BulkResult {
+	result := &BulkResult{Affected: len(ids)}
+	if selection.Filter == nil {
+		requested := make(map[uint]bool, len(selection.IDs))
+		for _, id := range selection.IDs {
+			requested[id] = true
+		}
+		result.NotFound = len(requested) - len(ids)
+	}
+	return result
+}
+
+func (s service) RestoreUser(ctx context.Context, id uint) error {
+	ctx, span := tracing.Tracer.Start(ctx, "user.Service/RestoreUser")
+	defer span.End()
+
+	err := s.Repository.RestoreUser(ctx, id)
 	if err != nil {
 		return err
 	}
+	s.audit.Record(ctx, id, models.AuditUserRestored)
+	return nil
+}
+
+// EraseUser anonymizes a user for good, whether or not they were soft deleted first
+func (s service) EraseUser(ctx context.Context, id uint) error {
+	ctx, span := tracing.Tracer.Start(ctx, "user.Service/EraseUser")
+	defer span.End()
 
+	err := s.erase(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return s.sessions.RevokeUserSessions(ctx, id)
+}
+
+// erase anonymizes the user and drops their cached data export, which still holds the erased data
+func (s service) erase(ctx context.Context, id uint) error {
+	err := s.Repository.EraseUser(ctx, id)
+	if err != nil {
+		return err
+	}
+	s.audit.Record(ctx, id, models.AuditUserErased)
+	return s.archives.DeleteArchive(ctx, id)
+}
+
+// PurgeDeletedUsers erases users that have been soft deleted for longer than the retention period
+func (s service) PurgeDeletedUsers(ctx context.Context) (int, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "user.Service/PurgeDeletedUsers")
+	defer span.End()
+
+	retention := s.config.Current().Retention.DeletedUsers
+	if retention <= 0 {
+		return 0, nil
+	}
+	deletedBefore := time.Now().Add(-retention)
+
+	purged := 0
+	for {
+		ids, err := s.Repository.GetErasableUserIDs(ctx, deletedBefore, purgeBatchSize)
+		if err != nil {
+			return purged, err
+		}
+		for _, id := range ids {
+			// Another instance may have erased the user in the meantime
+			err := s.erase(ctx, id)
+			if errors.Is(err, ErrUserNotFound) {
+				continue
+			}
+			if err != nil {
+				return purged, err
+			}
+			purged++
+		}
+		if len(ids) < purgeBatchSize {
+			return purged, nil
+		}
+	}
+}
+
+// callerIsAdmin reports whether the caller may act as an admin, which also takes the users:admin scope
+func (s service) callerIsAdmin(ctx context.Context, caller Caller) (bool, error) {
+	if !caller.AdminScope {
+		return false, nil
+	}
+	return s.IsAdmin(ctx, caller.UserID)
+}
+
+// IsAdmin reports whether the user exists and has the admin role
+func (s service) IsAdmin(ctx context.Context, id uint) (bool, error) {
+	user, err := s.Repository.GetUserByID(ctx, id)
+	if errors.Is(err, ErrUserNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return user.Role == models.RoleAdmin, nil
+}
+
+func (s service) ChangePassword(ctx context.Context, id uint, req ChangePassword) error {
+	ctx, span := tracing.Tracer.Start(ctx, "user.Service/ChangePassword")
+	defer span.End()
+
+	user, err := s.Repository.GetUserByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	// Require the current password so a stolen session can't lock the owner out
+	ok, _, err := s.hasher.Verify(req.CurrentPassword, user.HashedPassword)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidPassword
+	}
+
+	// Reject weak and breached passwords
+	err = s.policy.Check(ctx, req.NewPassword, user.Email, user.FirstName, user.LastName)
+	if err != nil {
+		return err
+	}
+
+	err = SetPassword(ctx, s.Repository, s.hasher, user, req.NewPassword, s.config.Current().Password.Policy.HistorySize)
+	if err != nil {
+		return err
+	}
+	s.audit.Record(ctx, id, models.AuditPasswordChanged)
 	return nil
 }
+
+// SetPassword replaces a user's password, refusing the current one and the last historySize ones
+func SetPassword(ctx context.Context, repository Repository, hasher password.Hasher, user *models.User, newPassword string, historySize uint) error {
+	hashes := []string{user.HashedPassword}
+	if historySize > 0 {
+		history, err := repository.GetPasswordHistory(ctx, user.ID, int(historySize))
+		if err != nil {
+			return err
+		}
+		hashes = append(hashes, history...)
+	}
+
+	reused, err := password.MatchesAny(hasher, newPassword, hashes)
+	if err != nil {
+		return err
+	}
+	if reused {
+		return ErrPasswordReused
+	}
+
+	hashedPassword, err := hasher.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+	return repository.ChangeUserPassword(ctx, user.ID, hashedPassword, int(historySize))
+}
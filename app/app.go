@@ -0,0 +1,266 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/labstack/gommon/log"
+	"go.learning/api/health"
+	"go.learning/config"
+	"go.learning/metrics"
+	"go.learning/middlewares"
+	"go.learning/tracing"
+	"gorm.io/gorm"
+)
+
+const defaultShutdownTimeout = 10 * time.Second
+
+// Worker is a background job that runs until its context is cancelled
+type Worker func(ctx context.Context)
+
+// App owns the HTTP server, background workers and the connections they share.
+// Components are started in dependency order and stopped in reverse order.
+type App struct {
+	cfg             config.Config
+	watcher         *config.Watcher
+	echo            *echo.Echo
+	db              *gorm.DB
+	redisClient     *redis.Client
+	healthService   health.Service
+	shutdownTracing func(context.Context) error
+	workers         []Worker
+}
+
+// New connects to every dependency and registers routes. Anything opened before a failure is closed again.
+func New(ctx context.Context, cfg config.Config) (_ *App, err error) {
+	a := &App{cfg: cfg, watcher: config.NewWatcher(cfg)}
+	defer func() {
+		if err != nil {
+			a.close(ctx)
+		}
+	}()
+
+	// Set up tracing
+	a.shutdownTracing, err = tracing.Init(ctx, cfg.Tracing)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing tracing: %w", err)
+	}
+
+	// Set up database connection
+	a.db, err = NewPostgres(cfg.Databasepostgres)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set up Redis connection
+	a.redisClient, err = NewRedis(ctx, cfg.Redis)
+	if err != nil {
+		return nil, err
+	}
+
+	// Expose session store metrics
+	if err = metrics.RegisterActiveSessions(a.redisClient); err != nil {
+		return nil, fmt.Errorf("error registering session metrics: %w", err)
+	}
+
+	// Automigrate the database
+	if err = Migrate(a.db); err != nil {
+		return nil, err
+	}
+
+	// Set up health checks
+	a.healthService = health.NewService(a.db, a.redisClient, cfg.Server.HealthTimeout)
+
+	a.echo = newEcho(a.watcher)
+	if err = registerRoutes(a.echo, a.db, a.redisClient, a.healthService, a.watcher, a.AddWorker); err != nil {
+		return nil, err
+	}
+
+	// Apply runtime-reloadable settings now and whenever the config file changes
+	a.applyConfig(cfg)
+	a.watcher.Subscribe(a.applyConfig)
+
+	return a, nil
+}
+
+func newEcho(watcher *config.Watcher) *echo.Echo {
+	cfg := watcher.Current()
+	e := echo.New()
+	e.HideBanner = true
+
+	// Only believe X-Forwarded-For from our own proxies, or clients could pick the IP they are rate limited by
+	e.IPExtractor = ipExtractor(cfg.Server.TrustedProxies)
+
+	// Set Cors origin and methods
+	e.Use(middlewares.CORSMiddleware(watcher))
+
+	// Set up middleware for logging
+	e.Use(middleware.Logger())
+
+	// Set up middleware for request tracing
+	e.Use(middlewares.TracingMiddleware())
+
+	// Set up middleware for request metrics
+	e.Use(middlewares.MetricsMiddleware())
+
+	// Set up middleware for per-request timeouts
+	e.Use(middlewares.TimeoutMiddleware(cfg.Server.RequestTimeout, streamingRoute))
+
+	return e
+}
+
+// ipExtractor reads the client IP from X-Forwarded-For behind trusted proxies, and from the connection otherwise
+func ipExtractor(trustedProxies []string) echo.IPExtractor {
+	if len(trustedProxies) == 0 {
+		return echo.ExtractIPDirect()
+	}
+	// Only the configured ranges; echo would otherwise also trust every private and loopback address
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, proxy := range trustedProxies {
+		// Validated at load
+		_, ipNet, _ := net.ParseCIDR(proxy)
+		options = append(options, echo.TrustIPRange(ipNet))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
+
+// streamingRoutes write their response while reading the database and may run past the request timeout
+var streamingRoutes = map[string]bool{
+	"/user/export": true,
+}
+
+func streamingRoute(c echo.Context) bool {
+	return streamingRoutes[c.Path()]
+}
+
+// unloggedRoutes are polled by infrastructure and are left out of the stored request logs
+var unloggedRoutes = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+	"/metrics": true,
+}
+
+func unloggedRoute(c echo.Context) bool {
+	return unloggedRoutes[c.Path()]
+}
+
+// applyConfig applies the settings that can change without a restart
+func (a *App) applyConfig(cfg config.Config) {
+	level := logLevel(cfg.Log.Level)
+	log.SetLevel(level)
+	a.echo.Logger.SetLevel(level)
+}
+
+func logLevel(level string) log.Lvl {
+	switch level {
+	case "debug":
+		return log.DEBUG
+	case "warn":
+		return log.WARN
+	case "error":
+		return log.ERROR
+	case "off":
+		return log.OFF
+	default:
+		return log.INFO
+	}
+}
+
+// AddWorker registers a background job started alongside the HTTP server
+func (a *App) AddWorker(w Worker) {
+	a.workers = append(a.workers, w)
+}
+
+// Run serves HTTP and runs workers until ctx is cancelled or the server fails, then shuts everything down
+func (a *App) Run(ctx context.Context) error {
+	// Derive request contexts from a base context that is cancelled after draining
+	baseCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	a.echo.Server.BaseContext = func(net.Listener) context.Context { return baseCtx }
+
+	// Watch the config file for runtime-reloadable changes
+	a.watcher.Start()
+
+	// Start background workers
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	var workers sync.WaitGroup
+	for _, w := range a.workers {
+		workers.Add(1)
+		go func(w Worker) {
+			defer workers.Done()
+			w(workerCtx)
+		}(w)
+	}
+
+	// Start the HTTP server
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- a.echo.Start(fmt.Sprintf(":%d", a.cfg.Server.Port))
+	}()
+
+	var runErr error
+	select {
+	case err := <-serverErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			runErr = fmt.Errorf("http server failed: %w", err)
+		}
+	case <-ctx.Done():
+		log.Info("shutting down")
+	}
+
+	// Fail readiness first so no new traffic is routed here while draining
+	a.healthService.SetShuttingDown()
+	if delay := a.cfg.Server.ShutdownDelay; delay > 0 && runErr == nil {
+		time.Sleep(delay)
+	}
+
+	// The shutdown timeout starts once the delay is over, so it is all spent on draining
+	shutdownTimeout := a.cfg.Server.ShutdownTimeout
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = defaultShutdownTimeout
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// Drain in-flight requests, then abort DB and Redis work of requests that did not finish in time
+	if err := a.echo.Shutdown(shutdownCtx); err != nil {
+		runErr = errors.Join(runErr, fmt.Errorf("http server shutdown: %w", err))
+	}
+	cancelRequests()
+
+	// Stop background workers
+	stopWorkers()
+	workers.Wait()
+
+	return errors.Join(runErr, a.close(shutdownCtx))
+}
+
+// close releases connections in reverse order of creation
+func (a *App) close(ctx context.Context) error {
+	var errs []error
+	if a.redisClient != nil {
+		if err := a.redisClient.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("close Redis: %w", err))
+		}
+	}
+	if a.db != nil {
+		if err := ClosePostgres(a.db); err != nil {
+			errs = append(errs, fmt.Errorf("close DBPortgre: %w", err))
+		}
+	}
+	if a.shutdownTracing != nil {
+		if err := a.shutdownTracing(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("shutdown tracing: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,85 @@
+package app
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/gommon/log"
+	"go.learning/config"
+	"go.learning/metrics"
+	"go.learning/models"
+	"go.learning/tracing"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// NewPostgres opens the Postgres connection pool with metrics and tracing plugins installed
+func NewPostgres(c config.Databasepostgres) (*gorm.DB, error) {
+	dsn := fmt.Sprintf(
+		"host=%s port=%d dbname=%s user=%s password=%s sslmode=%s",
+		c.Host,
+		c.Port,
+		c.DBName,
+		c.Username,
+		c.Password,
+		c.SSLMode,
+	)
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to DBPortgre: %w", err)
+	}
+	if err := db.Use(metrics.GormPlugin{}); err != nil {
+		ClosePostgres(db)
+		return nil, fmt.Errorf("error registering DBPortgre metrics: %w", err)
+	}
+	if err := db.Use(tracing.GormPlugin{}); err != nil {
+		ClosePostgres(db)
+		return nil, fmt.Errorf("error registering DBPortgre tracing: %w", err)
+	}
+	log.Infof("connected to database Portgre %s:%d", c.Host, c.Port)
+	return db, nil
+}
+
+// ClosePostgres closes the connection pool behind db
+func ClosePostgres(db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+// NewRedis connects to Redis with metrics and tracing hooks installed
+func NewRedis(ctx context.Context, c config.Redis) (*redis.Client, error) {
+	redisClient := redis.NewClient(&redis.Options{
+		Addr:     fmt.Sprintf("%s:%d", c.Host, c.Port),
+		Password: c.Password,
+		DB:       int(c.DB),
+	})
+	redisClient.AddHook(metrics.RedisHook{})
+	redisClient.AddHook(tracing.RedisHook{})
+	if err := redisClient.Ping(ctx).Err(); err != nil {
+		redisClient.Close()
+		return nil, fmt.Errorf("error connecting to Redis: %w", err)
+	}
+	log.Infof("connected to Redis %s:%d", c.Host, c.Port)
+	return redisClient, nil
+}
+
+// Migrate automigrates every model owned by this service
+func Migrate(db *gorm.DB) error {
+	err := db.AutoMigrate(&models.User{}, &models.Logger{}, &models.APIKey{}, &models.UserIdentity{}, &models.OAuthClient{}, &models.WebAuthnCredential{}, &models.PasswordHistory{}, &models.AuditEvent{})
+	if err != nil {
+		return fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	// The email index used to cover deleted users too, which blocked re-registration
+	if db.Migrator().HasIndex(&models.User{}, "idx_users_email") {
+		if err := db.Migrator().DropIndex(&models.User{}, "idx_users_email"); err != nil {
+			return fmt.Errorf("failed to drop old email index: %w", err)
+		}
+	}
+	log.Info("database migration completed")
+	return nil
+}
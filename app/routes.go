@@ -0,0 +1,169 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.learning/api/apikey"
+	"go.learning/api/audit"
+	"go.learning/api/auth"
+	"go.learning/api/export"
+	"go.learning/api/health"
+	"go.learning/api/oauth"
+	"go.learning/api/oidc"
+	"go.learning/api/passkey"
+	"go.learning/api/user"
+	"go.learning/config"
+	"go.learning/mailer"
+	"go.learning/middlewares"
+	"go.learning/password"
+	"go.learning/ratelimit"
+	"go.learning/utils"
+	"gorm.io/gorm"
+)
+
+func registerRoutes(e *echo.Echo, dbPG *gorm.DB, redisClient *redis.Client, healthService health.Service, watcher *config.Watcher, addWorker func(Worker)) error {
+	cfg := watcher.Current()
+
+	hasher, err := password.New(cfg.Password)
+	if err != nil {
+		return fmt.Errorf("error setting up password hashing: %w", err)
+	}
+
+	passwordPolicy, err := password.NewPolicy(cfg.Password.Policy)
+	if err != nil {
+		return fmt.Errorf("error setting up password policy: %w", err)
+	}
+
+	auditRepository := audit.NewRepository(dbPG)
+	auditService := audit.NewService(auditRepository)
+
+	userRepository := user.NewRepository(dbPG)
+
+	authService := auth.NewService(userRepository, redisClient, watcher, mailer.New(cfg.Mail), hasher, passwordPolicy, auditService)
+	authHandler := auth.NewHandler(authService)
+
+	exportRepository := export.NewRepository(dbPG)
+	exportService := export.NewService(exportRepository, redisClient, watcher)
+	exportHandler := export.NewHandler(exportService)
+
+	userService := user.NewService(userRepository, hasher, passwordPolicy, watcher, authService, exportService, auditService)
+	userHandler := user.NewHandler(userService, user.NewImporter(userRepository, hasher, passwordPolicy, authService))
+	addWorker(purgeDeletedUsers(userService, cfg.Retention.PurgeInterval))
+
+	apiKeyRepository := apikey.NewRepository(dbPG)
+	apiKeyService := apikey.NewService(apiKeyRepository)
+	apiKeyHandler := apikey.NewHandler(apiKeyService)
+
+	oidcRepository := oidc.NewRepository(dbPG)
+	oidcService := oidc.NewService(oidcRepository, userRepository, authService, redisClient, cfg.OIDC)
+	oidcHandler := oidc.NewHandler(oidcService)
+
+	passkeyRepository := passkey.NewRepository(dbPG)
+	passkeyService, err := passkey.NewService(passkeyRepository, userRepository, authService, redisClient, cfg.WebAuthn)
+	if err != nil {
+		return fmt.Errorf("error setting up passkeys: %w", err)
+	}
+	passkeyHandler := passkey.NewHandler(passkeyService)
+
+	oauthRepository := oauth.NewRepository(dbPG)
+	oauthService := oauth.NewService(oauthRepository, authService, redisClient, watcher)
+	oauthHandler := oauth.NewHandler(oauthService)
+
+	healthHandler := health.NewHandler(healthService)
+
+	// Middleware
+	tokenAuthMiddleware := middlewares.NewTokenAuthMiddleware(redisClient, cfg.JWT.SecretKey)
+	authMiddleware := middlewares.NewAuthMiddleware(tokenAuthMiddleware, apiKeyService, userService)
+	rateLimitMiddleware := middlewares.NewRateLimitMiddleware(
+		ratelimit.NewFallbackLimiter(ratelimit.NewRedisLimiter(redisClient), ratelimit.NewMemoryLimiter()),
+		watcher,
+	)
+	apiRateLimit := rateLimitMiddleware.Limit("api", middlewares.RateLimitByAPIKey)
+
+	// Store request logs so they can be included in data exports
+	e.Use(middlewares.RequestLogMiddleware(auditService, unloggedRoute))
+
+	// Auth routes
+	e.POST("/login", authHandler.Login, rateLimitMiddleware.Limit("login", middlewares.RateLimitByIP))
+	e.POST("/refresh-token", authHandler.RefreshToken, rateLimitMiddleware.Limit("refresh-token", middlewares.RateLimitByIP))
+	e.POST("/logout", authHandler.Logout)
+	e.POST("/login/magic-link", authHandler.RequestMagicLink, rateLimitMiddleware.Limit("login", middlewares.RateLimitByIP))
+	e.POST("/login/magic-link/verify", authHandler.VerifyMagicLink, rateLimitMiddleware.Limit("login", middlewares.RateLimitByIP))
+	e.POST("/password/forgot", authHandler.ForgotPassword, rateLimitMiddleware.Limit("login", middlewares.RateLimitByIP))
+	e.POST("/password/reset", authHandler.ResetPassword, rateLimitMiddleware.Limit("login", middlewares.RateLimitByIP))
+
+	// OpenID Connect routes
+	oidc_routes := e.Group("/auth")
+
+	oidc_routes.GET("/oidc/:provider/login", oidcHandler.Login, rateLimitMiddleware.Limit("login", middlewares.RateLimitByIP))
+	oidc_routes.GET("/oidc/:provider/callback", oidcHandler.Callback, rateLimitMiddleware.Limit("login", middlewares.RateLimitByIP))
+	oidc_routes.GET("/identities", oidcHandler.GetIdentityList, tokenAuthMiddleware.TokenAuthMiddleware())
+
+	// Passkey routes
+	e.POST("/login/passkey/begin", passkeyHandler.BeginLogin, rateLimitMiddleware.Limit("login", middlewares.RateLimitByIP))
+	e.POST("/login/passkey/finish", passkeyHandler.FinishLogin, rateLimitMiddleware.Limit("login", middlewares.RateLimitByIP))
+
+	passkey_routes := e.Group("/passkeys")
+
+	passkey_routes.POST("/register/begin", passkeyHandler.BeginRegistration, tokenAuthMiddleware.TokenAuthMiddleware(), apiRateLimit)
+	passkey_routes.POST("/register/finish", passkeyHandler.FinishRegistration, tokenAuthMiddleware.TokenAuthMiddleware(), apiRateLimit)
+	passkey_routes.GET("", passkeyHandler.GetList, tokenAuthMiddleware.TokenAuthMiddleware(), apiRateLimit)
+	passkey_routes.DELETE("/:id", passkeyHandler.Delete, tokenAuthMiddleware.TokenAuthMiddleware(), apiRateLimit)
+
+	// User routes
+	e.POST("/register", userHandler.Register, rateLimitMiddleware.Limit("register", middlewares.RateLimitByIP))
+
+	user_routes := e.Group("/user")
+
+	user_routes.GET("", userHandler.GetList, authMiddleware.Authenticate(), apiRateLimit, authMiddleware.RequireScope(apikey.ScopeUsersRead))
+	user_routes.GET("/export", userHandler.Export, authMiddleware.Authenticate(), apiRateLimit, authMiddleware.RequireScope(apikey.ScopeUsersRead))
+	user_routes.GET("/:id", userHandler.Get, authMiddleware.Authenticate(), apiRateLimit, authMiddleware.RequireScope(apikey.ScopeUsersRead))
+	user_routes.PUT("", userHandler.Update, authMiddleware.Authenticate(), apiRateLimit, authMiddleware.RequireScope(apikey.ScopeUsersWrite))
+	user_routes.PATCH("/:id", userHandler.Patch, authMiddleware.Authenticate(), apiRateLimit, authMiddleware.RequireScope(apikey.ScopeUsersWrite))
+	user_routes.DELETE("/:id", userHandler.Delete, authMiddleware.Authenticate(), apiRateLimit, authMiddleware.RequireScope(apikey.ScopeUsersWrite))
+	user_routes.POST("/:id/restore", userHandler.Restore, authMiddleware.Authenticate(), apiRateLimit, authMiddleware.RequireScope(apikey.ScopeUsersAdmin), authMiddleware.RequireAdmin())
+	user_routes.POST("/:id/erase", userHandler.Erase, authMiddleware.Authenticate(), apiRateLimit, authMiddleware.RequireScope(apikey.ScopeUsersAdmin), authMiddleware.RequireAdmin())
+	user_routes.PATCH("/bulk", userHandler.BulkUpdate, authMiddleware.Authenticate(), apiRateLimit, authMiddleware.RequireScope(apikey.ScopeUsersAdmin), authMiddleware.RequireAdmin())
+	user_routes.DELETE("/bulk", userHandler.BulkDelete, authMiddleware.Authenticate(), apiRateLimit, authMiddleware.RequireScope(apikey.ScopeUsersAdmin), authMiddleware.RequireAdmin())
+	user_routes.POST("/import", userHandler.Import, authMiddleware.Authenticate(), apiRateLimit, authMiddleware.RequireScope(apikey.ScopeUsersAdmin), authMiddleware.RequireAdmin())
+	user_routes.GET("/:id/export", exportHandler.ExportUser, authMiddleware.Authenticate(), apiRateLimit, authMiddleware.RequireScope(apikey.ScopeUsersAdmin), authMiddleware.RequireAdmin())
+	user_routes.POST("/password", userHandler.ChangePassword, tokenAuthMiddleware.AllowRestricted(utils.ScopePasswordChange), apiRateLimit)
+
+	// Data export of the current user
+	e.GET("/me/export", exportHandler.ExportMe, tokenAuthMiddleware.TokenAuthMiddleware(), apiRateLimit)
+
+	// API key routes, managed with a user token only
+	api_key_routes := e.Group("/api-keys")
+
+	api_key_routes.POST("", apiKeyHandler.Create, tokenAuthMiddleware.TokenAuthMiddleware(), apiRateLimit)
+	api_key_routes.GET("", apiKeyHandler.GetList, tokenAuthMiddleware.TokenAuthMiddleware(), apiRateLimit)
+	api_key_routes.DELETE("/:id", apiKeyHandler.Revoke, tokenAuthMiddleware.TokenAuthMiddleware(), apiRateLimit)
+
+	// OAuth2 authorization server routes
+	oauth_routes := e.Group("/oauth")
+
+	oauth_routes.POST("/clients", oauthHandler.CreateClient, tokenAuthMiddleware.TokenAuthMiddleware(), apiRateLimit, authMiddleware.RequireAdmin())
+	oauth_routes.GET("/clients", oauthHandler.GetClientList, tokenAuthMiddleware.TokenAuthMiddleware(), apiRateLimit)
+	oauth_routes.DELETE("/clients/:id", oauthHandler.DeleteClient, tokenAuthMiddleware.TokenAuthMiddleware(), apiRateLimit)
+	oauth_routes.GET("/authorize", oauthHandler.Authorize, tokenAuthMiddleware.TokenAuthMiddleware(), rateLimitMiddleware.Limit("oauth", middlewares.RateLimitByIP))
+	oauth_routes.POST("/token", oauthHandler.Token, rateLimitMiddleware.Limit("oauth", middlewares.RateLimitByIP))
+	oauth_routes.POST("/introspect", oauthHandler.Introspect, rateLimitMiddleware.Limit("oauth", middlewares.RateLimitByIP))
+	oauth_routes.POST("/revoke", oauthHandler.Revoke, rateLimitMiddleware.Limit("oauth", middlewares.RateLimitByIP))
+
+	// Health routes
+	e.GET("/healthz", healthHandler.Liveness)
+	e.GET("/readyz", healthHandler.Readiness)
+
+	// Metrics route
+	e.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
+
+	e.GET("/", func(c echo.Context) error {
+		return c.String(http.StatusOK, "Hello, World!")
+	})
+
+	return nil
+}
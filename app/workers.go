@@ -0,0 +1,31 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/labstack/gommon/log"
+	"go.learning/api/user"
+)
+
+// purgeDeletedUsers periodically erases users that were soft deleted longer ago than the retention period
+func purgeDeletedUsers(userService user.Service, interval time.Duration) Worker {
+	return func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				purged, err := userService.PurgeDeletedUsers(ctx)
+				if err != nil {
+					log.Errorf("failed to purge deleted users: %v", err)
+				}
+				if purged > 0 {
+					log.Infof("erased %d users past the deleted user retention", purged)
+				}
+			}
+		}
+	}
+}
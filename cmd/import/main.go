@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/gommon/log"
+	"go.learning/api/user"
+	"go.learning/app"
+	"go.learning/config"
+	"go.learning/password"
+	"go.learning/utils"
+)
+
+// Imports users from a CSV or NDJSON file and prints the per-row report as JSON.
+// Exits with status 2 when any row was invalid or failed.
+//
+//	go run ./cmd/import -file users.csv [-format csv|ndjson] [-dry-run]
+func main() {
+	file := flag.String("file", "", "CSV or NDJSON file to import")
+	format := flag.String("format", "", "csv or ndjson, detected from the file extension by default")
+	dryRun := flag.Bool("dry-run", false, "validate and report without writing")
+	flag.Parse()
+
+	// Keep stdout for the report
+	log.SetOutput(os.Stderr)
+
+	report, err := run(*file, *format, *dryRun)
+	if err != nil {
+		log.Errorf("%v", err)
+		os.Exit(1)
+	}
+	if report.Invalid > 0 || report.Failed > 0 {
+		os.Exit(2)
+	}
+}
+
+func run(file, format string, dryRun bool) (*user.ImportReport, error) {
+	if file == "" {
+		return nil, errors.New("-file is required")
+	}
+	if format == "" {
+		format = formatFromExtension(file)
+	}
+
+	conf, err := config.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	db, err := app.NewPostgres(conf.Databasepostgres)
+	if err != nil {
+		return nil, err
+	}
+	defer app.ClosePostgres(db)
+
+	// Deactivating users ends their sessions, which live in Redis
+	redisClient, err := app.NewRedis(ctx, conf.Redis)
+	if err != nil {
+		return nil, err
+	}
+	defer redisClient.Close()
+
+	hasher, err := password.New(conf.Password)
+	if err != nil {
+		return nil, fmt.Errorf("error setting up password hashing: %w", err)
+	}
+	policy, err := password.NewPolicy(conf.Password.Policy)
+	if err != nil {
+		return nil, fmt.Errorf("error setting up password policy: %w", err)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	importer := user.NewImporter(user.NewRepository(db), hasher, policy, sessionRevoker{redisClient})
+	report, err := importer.Import(ctx, format, f, dryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return nil, err
+	}
+	log.Infof("%d rows: %d created, %d updated, %d invalid, %d failed (dry run: %t)",
+		report.Total, report.Created, report.Updated, report.Invalid, report.Failed, report.DryRun)
+	return report, nil
+}
+
+// sessionRevoker ends a user's sessions the same way the API does
+type sessionRevoker struct {
+	redisClient *redis.Client
+}
+
+func (r sessionRevoker) RevokeUserSessions(ctx context.Context, userID uint) error {
+	return utils.RevokeSubjectSessions(ctx, r.redisClient, fmt.Sprintf("%d", userID))
+}
+
+func formatFromExtension(file string) string {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".csv":
+		return user.FormatCSV
+	case ".ndjson", ".jsonl":
+		return user.FormatNDJSON
+	}
+	return ""
+}
@@ -1,8 +1,11 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -12,10 +15,28 @@ type Config struct {
 	Databasepostgres Databasepostgres `mapstructure:"databasepostgres"`
 	Redis            Redis            `mapstructure:"redis"`
 	JWT              JWT              `mapstructure:"jwt"`
+	Tracing          Tracing          `mapstructure:"tracing"`
+	Log              Log              `mapstructure:"log"`
+	CORS             CORS             `mapstructure:"cors"`
+	RateLimit        RateLimit        `mapstructure:"ratelimit"`
+	OIDC             OIDC             `mapstructure:"oidc"`
+	Mail             Mail             `mapstructure:"mail"`
+	MagicLink        MagicLink        `mapstructure:"magiclink"`
+	PasswordReset    PasswordReset    `mapstructure:"passwordreset"`
+	WebAuthn         WebAuthn         `mapstructure:"webauthn"`
+	Password         Password         `mapstructure:"password"`
+	Retention        Retention        `mapstructure:"retention"`
+	Export           Export           `mapstructure:"export"`
+	Environment      string           `mapstructure:"environment"` // selects the config.<environment>.yml profile
 }
 
 type ServerConfig struct {
-	Port uint `mapstructure:"port"`
+	Port            uint          `mapstructure:"port"`
+	RequestTimeout  time.Duration `mapstructure:"requesttimeout"`  // deadline for DB and Redis work done by a request
+	HealthTimeout   time.Duration `mapstructure:"healthtimeout"`   // deadline for each readiness dependency ping
+	ShutdownDelay   time.Duration `mapstructure:"shutdowndelay"`   // time to report not-ready before the listener closes
+	ShutdownTimeout time.Duration `mapstructure:"shutdowntimeout"` // time allowed for in-flight requests to drain
+	TrustedProxies  []string      `mapstructure:"trustedproxies"`  // CIDRs of proxies whose X-Forwarded-For is believed; empty uses the peer address
 }
 
 type Databasepostgres struct {
@@ -29,12 +50,167 @@ type Databasepostgres struct {
 }
 
 type Redis struct {
-	Host string `mapstructure:"host"`
-	Port uint   `mapstructure:"port"`
+	Host     string `mapstructure:"host"`
+	Port     uint   `mapstructure:"port"`
+	Password string `mapstructure:"password"`
+	DB       uint   `mapstructure:"db"`
 }
 
 type JWT struct {
-	SecretKey string `mapstructure:"secretkey"`
+	SecretKey          string        `mapstructure:"secretkey"`
+	AccessTokenTTL     time.Duration `mapstructure:"accesstokenttl"`
+	RefreshTokenTTL    time.Duration `mapstructure:"refreshtokenttl"`
+	SlidingSession     bool          `mapstructure:"slidingsession"`     // refreshing also issues a new refresh token
+	MaxSessionLifetime time.Duration `mapstructure:"maxsessionlifetime"` // absolute cap measured from login, 0 for none
+}
+
+type Tracing struct {
+	Enabled     bool    `mapstructure:"enabled"`
+	ServiceName string  `mapstructure:"servicename"`
+	Exporter    string  `mapstructure:"exporter"` // otlp or stdout
+	Endpoint    string  `mapstructure:"endpoint"` // OTLP/HTTP collector host:port
+	Insecure    bool    `mapstructure:"insecure"`
+	SampleRatio float64 `mapstructure:"sampleratio"`
+}
+
+type Log struct {
+	Level string `mapstructure:"level"` // debug, info, warn, error or off
+}
+
+type CORS struct {
+	AllowOrigins     []string      `mapstructure:"alloworigins"` // exact origins, "*" or wildcard subdomains like https://*.example.com
+	AllowMethods     []string      `mapstructure:"allowmethods"`
+	AllowHeaders     []string      `mapstructure:"allowheaders"`
+	ExposeHeaders    []string      `mapstructure:"exposeheaders"`
+	AllowCredentials bool          `mapstructure:"allowcredentials"`
+	MaxAge           time.Duration `mapstructure:"maxage"`
+}
+
+type RateLimit struct {
+	Enabled  bool                       `mapstructure:"enabled"`
+	Policies map[string]RateLimitPolicy `mapstructure:"policies"` // keyed by the policy name used at route registration
+}
+
+type RateLimitPolicy struct {
+	Limit  int           `mapstructure:"limit"`
+	Window time.Duration `mapstructure:"window"`
+}
+
+type OIDC struct {
+	Providers map[string]OIDCProvider `mapstructure:"providers"` // keyed by the name used in /auth/oidc/:provider routes
+}
+
+type OIDCProvider struct {
+	IssuerURL    string   `mapstructure:"issuerurl"`
+	ClientID     string   `mapstructure:"clientid"`
+	ClientSecret string   `mapstructure:"clientsecret"`
+	RedirectURL  string   `mapstructure:"redirecturl"` // must point at /auth/oidc/:provider/callback
+	Scopes       []string `mapstructure:"scopes"`
+}
+
+type Mail struct {
+	Driver   string `mapstructure:"driver"` // smtp, or log to write messages to the log in development
+	From     string `mapstructure:"from"`
+	Host     string `mapstructure:"host"`
+	Port     uint   `mapstructure:"port"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+}
+
+type MagicLink struct {
+	URL string        `mapstructure:"url"` // page that receives ?token= and posts it to /login/magic-link/verify
+	TTL time.Duration `mapstructure:"ttl"`
+}
+
+type PasswordReset struct {
+	URL string        `mapstructure:"url"` // page that receives ?token= and posts it with the new password to /password/reset
+	TTL time.Duration `mapstructure:"ttl"`
+}
+
+type WebAuthn struct {
+	RPID          string   `mapstructure:"rpid"` // domain passkeys are bound to, e.g. example.com
+	RPDisplayName string   `mapstructure:"rpdisplayname"`
+	RPOrigins     []string `mapstructure:"rporigins"` // origins allowed to run the ceremonies
+}
+
+type Password struct {
+	Algorithm  string         `mapstructure:"algorithm"` // argon2id or bcrypt for new hashes; both are always verified
+	Argon2id   Argon2id       `mapstructure:"argon2id"`
+	BcryptCost uint           `mapstructure:"bcryptcost"`
+	Policy     PasswordPolicy `mapstructure:"policy"`
+}
+
+// PasswordPolicy screens passwords at registration, reset and change-password
+type PasswordPolicy struct {
+	MinLength      uint          `mapstructure:"minlength"`
+	MinEntropy     float64       `mapstructure:"minentropy"`     // estimated bits
+	DictionaryFile string        `mapstructure:"dictionaryfile"` // extra rejected words, one per line
+	BreachedDir    string        `mapstructure:"breacheddir"`    // Pwned Passwords range files named <PREFIX>.txt; empty disables the check
+	HistorySize    uint          `mapstructure:"historysize"`    // previous passwords, besides the current one, that can't be reused
+	MaxAge         time.Duration `mapstructure:"maxage"`         // force a change after this age, 0 to never expire
+}
+
+// Retention controls how long soft-deleted data is kept before it is erased
+type Retention struct {
+	DeletedUsers  time.Duration `mapstructure:"deletedusers"`  // soft-deleted users are anonymized after this long, 0 to keep them
+	PurgeInterval time.Duration `mapstructure:"purgeinterval"` // how often the purge runs
+}
+
+// Export controls data subject access archives
+type Export struct {
+	InlineWait time.Duration `mapstructure:"inlinewait"` // archives ready within this time are returned directly, otherwise the client polls
+	ArchiveTTL time.Duration `mapstructure:"archivettl"` // how long a generated archive can be downloaded
+}
+
+// Argon2id parameters are stored in each hash, so raising them rehashes passwords on the next login
+type Argon2id struct {
+	Memory      uint `mapstructure:"memory"` // KiB
+	Iterations  uint `mapstructure:"iterations"`
+	Parallelism uint `mapstructure:"parallelism"`
+	SaltLength  uint `mapstructure:"saltlength"` // bytes
+	KeyLength   uint `mapstructure:"keylength"`  // bytes
+}
+
+func setDefaults() {
+	viper.SetDefault("server.port", 8080)
+	viper.SetDefault("server.requesttimeout", "10s")
+	viper.SetDefault("server.healthtimeout", "2s")
+	viper.SetDefault("server.shutdowndelay", "5s")
+	viper.SetDefault("server.shutdowntimeout", "10s")
+	viper.SetDefault("databasepostgres.port", 5432)
+	viper.SetDefault("databasepostgres.sslmode", "disable")
+	viper.SetDefault("redis.port", 6379)
+	viper.SetDefault("jwt.accesstokenttl", "24h")
+	viper.SetDefault("jwt.refreshtokenttl", "168h")
+	viper.SetDefault("tracing.servicename", "go.learning")
+	viper.SetDefault("tracing.exporter", "stdout")
+	viper.SetDefault("tracing.sampleratio", 1.0)
+	viper.SetDefault("log.level", "info")
+	viper.SetDefault("environment", "development")
+	viper.SetDefault("cors.allowmethods", []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"})
+	viper.SetDefault("cors.allowheaders", []string{"Origin", "Content-Type", "Accept", "Authorization", "If-Match"})
+	viper.SetDefault("cors.exposeheaders", []string{"Content-Length", "ETag"})
+	viper.SetDefault("cors.maxage", "10m")
+	viper.SetDefault("ratelimit.enabled", true)
+	viper.SetDefault("mail.driver", "log")
+	viper.SetDefault("mail.port", 587)
+	viper.SetDefault("magiclink.ttl", "15m")
+	viper.SetDefault("passwordreset.ttl", "30m")
+	viper.SetDefault("webauthn.rpdisplayname", "go.learning")
+	viper.SetDefault("password.algorithm", "argon2id")
+	viper.SetDefault("password.argon2id.memory", 64*1024)
+	viper.SetDefault("password.argon2id.iterations", 3)
+	viper.SetDefault("password.argon2id.parallelism", 2)
+	viper.SetDefault("password.argon2id.saltlength", 16)
+	viper.SetDefault("password.argon2id.keylength", 32)
+	viper.SetDefault("password.bcryptcost", 10)
+	viper.SetDefault("password.policy.minlength", 8)
+	viper.SetDefault("password.policy.minentropy", 40)
+	viper.SetDefault("password.policy.historysize", 5)
+	viper.SetDefault("retention.deletedusers", "720h")
+	viper.SetDefault("retention.purgeinterval", "1h")
+	viper.SetDefault("export.inlinewait", "2s")
+	viper.SetDefault("export.archivettl", "1h")
 }
 
 func LoadConfig() (config Config, err error) {
@@ -42,60 +218,192 @@ func LoadConfig() (config Config, err error) {
 	viper.AddConfigPath("config")
 	viper.SetConfigName("config")
 	viper.SetConfigType("yml")
+	setDefaults()
 
-	viper.AutomaticEnv()
-
-	err = viper.ReadInConfig()
+	err = readConfig()
 	if err != nil {
 		return c, err
 	}
 
-	viper.Unmarshal(&c)
+	return load()
+}
+
+// readConfig reads the base config file and layers the environment profile over it
+func readConfig() error {
+	// The config file is optional when every required field comes from the environment
+	err := viper.ReadInConfig()
+	if err != nil && !errors.As(err, &viper.ConfigFileNotFoundError{}) {
+		return err
+	}
+	return mergeProfile()
+}
+
+// mergeProfile layers config/config.<environment>.yml, if present, over the base config file
+func mergeProfile() error {
+	environment := viper.GetString("environment")
+	if value, ok := os.LookupEnv(envPrefix + "ENV"); ok && value != "" {
+		environment = value
+	}
+	viper.Set("environment", environment)
+
+	path := profilePath(environment)
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = viper.MergeConfig(file)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return nil
+}
+
+func profilePath(environment string) string {
+	return filepath.Join("config", "config."+environment+".yml")
+}
+
+// load decodes the config file already read by viper, applies env overrides and validates the result
+func load() (Config, error) {
+	var c Config
+	err := viper.Unmarshal(&c)
+	if err != nil {
+		return c, fmt.Errorf("failed to decode config: %w", err)
+	}
+
+	env := &envReader{}
 
 	c.Server = ServerConfig{
-		Port: getEnvInteger("server.port", c.Server.Port),
+		Port:            env.getEnvInteger("SERVER_PORT", c.Server.Port),
+		RequestTimeout:  env.getEnvDuration("SERVER_REQUEST_TIMEOUT", c.Server.RequestTimeout),
+		HealthTimeout:   env.getEnvDuration("SERVER_HEALTH_TIMEOUT", c.Server.HealthTimeout),
+		ShutdownDelay:   env.getEnvDuration("SERVER_SHUTDOWN_DELAY", c.Server.ShutdownDelay),
+		ShutdownTimeout: env.getEnvDuration("SERVER_SHUTDOWN_TIMEOUT", c.Server.ShutdownTimeout),
+		TrustedProxies:  env.getEnvList("SERVER_TRUSTED_PROXIES", c.Server.TrustedProxies),
 	}
 
 	c.Databasepostgres = Databasepostgres{
-		Host:     getEnv("databasepostgres.host", c.Databasepostgres.Host),
-		Port:     getEnvInteger("databasepostgres.port", c.Databasepostgres.Port),
-		Username: getEnv("databasepostgres.username", c.Databasepostgres.Username),
-		Password: getEnv("databasepostgres.password", c.Databasepostgres.Password),
-		DBName:   getEnv("databasepostgres.dbname", c.Databasepostgres.DBName),
-		SSLMode:  getEnv("databasepostgres.sslmode", c.Databasepostgres.SSLMode),
-		Appname:  getEnv("databasepostgres.appname", c.Databasepostgres.Appname),
+		Host:     env.getEnv("DATABASE_HOST", c.Databasepostgres.Host),
+		Port:     env.getEnvInteger("DATABASE_PORT", c.Databasepostgres.Port),
+		Username: env.getEnv("DATABASE_USERNAME", c.Databasepostgres.Username),
+		Password: env.getEnvSecret("DATABASE_PASSWORD", c.Databasepostgres.Password),
+		DBName:   env.getEnv("DATABASE_DBNAME", c.Databasepostgres.DBName),
+		SSLMode:  env.getEnv("DATABASE_SSLMODE", c.Databasepostgres.SSLMode),
+		Appname:  env.getEnv("DATABASE_APPNAME", c.Databasepostgres.Appname),
+	}
+
+	c.Redis = Redis{
+		Host:     env.getEnv("REDIS_HOST", c.Redis.Host),
+		Port:     env.getEnvInteger("REDIS_PORT", c.Redis.Port),
+		Password: env.getEnvSecret("REDIS_PASSWORD", c.Redis.Password),
+		DB:       env.getEnvInteger("REDIS_DB", c.Redis.DB),
 	}
 
 	c.JWT = JWT{
-		SecretKey: getEnv("jwt.secretkey", c.JWT.SecretKey),
+		SecretKey:          env.getEnvSecret("JWT_SECRET_KEY", c.JWT.SecretKey),
+		AccessTokenTTL:     env.getEnvDuration("JWT_ACCESS_TOKEN_TTL", c.JWT.AccessTokenTTL),
+		RefreshTokenTTL:    env.getEnvDuration("JWT_REFRESH_TOKEN_TTL", c.JWT.RefreshTokenTTL),
+		SlidingSession:     env.getEnvBool("JWT_SLIDING_SESSION", c.JWT.SlidingSession),
+		MaxSessionLifetime: env.getEnvDuration("JWT_MAX_SESSION_LIFETIME", c.JWT.MaxSessionLifetime),
 	}
 
-	fmt.Printf("Port after %d\n", c.Server.Port)
+	c.Tracing = Tracing{
+		Enabled:     env.getEnvBool("TRACING_ENABLED", c.Tracing.Enabled),
+		ServiceName: env.getEnv("TRACING_SERVICE_NAME", c.Tracing.ServiceName),
+		Exporter:    env.getEnv("TRACING_EXPORTER", c.Tracing.Exporter),
+		Endpoint:    env.getEnv("TRACING_ENDPOINT", c.Tracing.Endpoint),
+		Insecure:    env.getEnvBool("TRACING_INSECURE", c.Tracing.Insecure),
+		SampleRatio: env.getEnvFloat("TRACING_SAMPLE_RATIO", c.Tracing.SampleRatio),
+	}
 
-	return c, nil
-}
+	c.CORS = CORS{
+		AllowOrigins:     env.getEnvList("CORS_ALLOW_ORIGINS", c.CORS.AllowOrigins),
+		AllowMethods:     env.getEnvList("CORS_ALLOW_METHODS", c.CORS.AllowMethods),
+		AllowHeaders:     env.getEnvList("CORS_ALLOW_HEADERS", c.CORS.AllowHeaders),
+		ExposeHeaders:    env.getEnvList("CORS_EXPOSE_HEADERS", c.CORS.ExposeHeaders),
+		AllowCredentials: env.getEnvBool("CORS_ALLOW_CREDENTIALS", c.CORS.AllowCredentials),
+		MaxAge:           env.getEnvDuration("CORS_MAX_AGE", c.CORS.MaxAge),
+	}
+
+	c.RateLimit.Enabled = env.getEnvBool("RATE_LIMIT_ENABLED", c.RateLimit.Enabled)
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+	for name, provider := range c.OIDC.Providers {
+		provider.ClientSecret = env.getEnvSecret("OIDC_"+envName(name)+"_CLIENT_SECRET", provider.ClientSecret)
+		c.OIDC.Providers[name] = provider
 	}
-	return defaultValue
-}
 
-func getEnvInteger(key string, defaultValue uint) uint {
-	if strValue := os.Getenv(key); strValue != "" {
-		var intValue uint
-		_, err := fmt.Sscan(strValue, &intValue)
-		if err == nil {
-			return intValue
-		}
+	c.Mail = Mail{
+		Driver:   env.getEnv("MAIL_DRIVER", c.Mail.Driver),
+		From:     env.getEnv("MAIL_FROM", c.Mail.From),
+		Host:     env.getEnv("MAIL_HOST", c.Mail.Host),
+		Port:     env.getEnvInteger("MAIL_PORT", c.Mail.Port),
+		Username: env.getEnv("MAIL_USERNAME", c.Mail.Username),
+		Password: env.getEnvSecret("MAIL_PASSWORD", c.Mail.Password),
+	}
+
+	c.MagicLink = MagicLink{
+		URL: env.getEnv("MAGIC_LINK_URL", c.MagicLink.URL),
+		TTL: env.getEnvDuration("MAGIC_LINK_TTL", c.MagicLink.TTL),
+	}
+
+	c.PasswordReset = PasswordReset{
+		URL: env.getEnv("PASSWORD_RESET_URL", c.PasswordReset.URL),
+		TTL: env.getEnvDuration("PASSWORD_RESET_TTL", c.PasswordReset.TTL),
+	}
+
+	c.WebAuthn = WebAuthn{
+		RPID:          env.getEnv("WEBAUTHN_RPID", c.WebAuthn.RPID),
+		RPDisplayName: env.getEnv("WEBAUTHN_RP_DISPLAY_NAME", c.WebAuthn.RPDisplayName),
+		RPOrigins:     env.getEnvList("WEBAUTHN_RP_ORIGINS", c.WebAuthn.RPOrigins),
 	}
-	return defaultValue
-}
 
-func getEnvBool(key string, defaultValue bool) bool {
-	if value := os.Getenv(key); value != "" {
-		return value == "true"
+	c.Password = Password{
+		Algorithm: env.getEnv("PASSWORD_ALGORITHM", c.Password.Algorithm),
+		Argon2id: Argon2id{
+			Memory:      env.getEnvInteger("PASSWORD_ARGON2ID_MEMORY", c.Password.Argon2id.Memory),
+			Iterations:  env.getEnvInteger("PASSWORD_ARGON2ID_ITERATIONS", c.Password.Argon2id.Iterations),
+			Parallelism: env.getEnvInteger("PASSWORD_ARGON2ID_PARALLELISM", c.Password.Argon2id.Parallelism),
+			SaltLength:  c.Password.Argon2id.SaltLength,
+			KeyLength:   c.Password.Argon2id.KeyLength,
+		},
+		BcryptCost: env.getEnvInteger("PASSWORD_BCRYPT_COST", c.Password.BcryptCost),
+		Policy: PasswordPolicy{
+			MinLength:      env.getEnvInteger("PASSWORD_MIN_LENGTH", c.Password.Policy.MinLength),
+			MinEntropy:     env.getEnvFloat("PASSWORD_MIN_ENTROPY", c.Password.Policy.MinEntropy),
+			DictionaryFile: env.getEnv("PASSWORD_DICTIONARY_FILE", c.Password.Policy.DictionaryFile),
+			BreachedDir:    env.getEnv("PASSWORD_BREACHED_DIR", c.Password.Policy.BreachedDir),
+			HistorySize:    env.getEnvInteger("PASSWORD_HISTORY_SIZE", c.Password.Policy.HistorySize),
+			MaxAge:         env.getEnvDuration("PASSWORD_MAX_AGE", c.Password.Policy.MaxAge),
+		},
 	}
-	return defaultValue
+
+	c.Retention = Retention{
+		DeletedUsers:  env.getEnvDuration("RETENTION_DELETED_USERS", c.Retention.DeletedUsers),
+		PurgeInterval: env.getEnvDuration("RETENTION_PURGE_INTERVAL", c.Retention.PurgeInterval),
+	}
+
+	c.Export = Export{
+		InlineWait: env.getEnvDuration("EXPORT_INLINE_WAIT", c.Export.InlineWait),
+		ArchiveTTL: env.getEnvDuration("EXPORT_ARCHIVE_TTL", c.Export.ArchiveTTL),
+	}
+
+	c.Log = Log{
+		Level: env.getEnv("LOG_LEVEL", c.Log.Level),
+	}
+
+	// Report unparsable overrides together with invalid fields
+	if err := c.Validate(); err != nil || len(env.errs) > 0 {
+		problems := env.errs
+		var validationErr *ValidationError
+		if errors.As(err, &validationErr) {
+			problems = append(problems, validationErr.Problems...)
+		}
+		return c, &ValidationError{Problems: problems}
+	}
+
+	return c, nil
 }
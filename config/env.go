@@ -0,0 +1,116 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envPrefix is prepended to every environment override, e.g. APP_DATABASE_HOST
+const envPrefix = "APP_"
+
+// envName converts a config key such as a provider name to its environment variable form
+func envName(key string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(key))
+}
+
+// envReader applies typed environment overrides and collects values that fail to parse
+type envReader struct {
+	errs []string
+}
+
+func (r *envReader) lookup(key string) (string, bool) {
+	value, ok := os.LookupEnv(envPrefix + key)
+	if !ok || value == "" {
+		return "", false
+	}
+	return value, true
+}
+
+func (r *envReader) invalid(key, value, kind string) {
+	r.errs = append(r.errs, fmt.Sprintf("%s%s: %q is not a valid %s", envPrefix, key, value, kind))
+}
+
+func (r *envReader) getEnv(key, defaultValue string) string {
+	if value, ok := r.lookup(key); ok {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvSecret reads key, or the file named by key_FILE, so secrets can be mounted instead of exported
+func (r *envReader) getEnvSecret(key, defaultValue string) string {
+	if path, ok := r.lookup(key + "_FILE"); ok {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			r.errs = append(r.errs, fmt.Sprintf("%s%s_FILE: %v", envPrefix, key, err))
+			return defaultValue
+		}
+		return strings.TrimRight(string(content), "\r\n")
+	}
+	return r.getEnv(key, defaultValue)
+}
+
+// getEnvList reads a comma-separated list
+func (r *envReader) getEnvList(key string, defaultValue []string) []string {
+	if strValue, ok := r.lookup(key); ok {
+		var values []string
+		for _, value := range strings.Split(strValue, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+		return values
+	}
+	return defaultValue
+}
+
+func (r *envReader) getEnvInteger(key string, defaultValue uint) uint {
+	if strValue, ok := r.lookup(key); ok {
+		value, err := strconv.ParseUint(strValue, 10, 0)
+		if err != nil {
+			r.invalid(key, strValue, "unsigned integer")
+			return defaultValue
+		}
+		return uint(value)
+	}
+	return defaultValue
+}
+
+func (r *envReader) getEnvFloat(key string, defaultValue float64) float64 {
+	if strValue, ok := r.lookup(key); ok {
+		value, err := strconv.ParseFloat(strValue, 64)
+		if err != nil {
+			r.invalid(key, strValue, "number")
+			return defaultValue
+		}
+		return value
+	}
+	return defaultValue
+}
+
+func (r *envReader) getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if strValue, ok := r.lookup(key); ok {
+		value, err := time.ParseDuration(strValue)
+		if err != nil {
+			r.invalid(key, strValue, "duration")
+			return defaultValue
+		}
+		return value
+	}
+	return defaultValue
+}
+
+func (r *envReader) getEnvBool(key string, defaultValue bool) bool {
+	if strValue, ok := r.lookup(key); ok {
+		value, err := strconv.ParseBool(strValue)
+		if err != nil {
+			r.invalid(key, strValue, "boolean")
+			return defaultValue
+		}
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,162 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"go.learning/utils"
+)
+
+// ValidationError lists every invalid field found while loading the configuration
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+type validator struct {
+	problems []string
+}
+
+func (v *validator) check(ok bool, field, format string, args ...interface{}) {
+	if !ok {
+		v.problems = append(v.problems, field+": "+fmt.Sprintf(format, args...))
+	}
+}
+
+func (v *validator) required(field, value string) {
+	v.check(value != "", field, "is required")
+}
+
+func (v *validator) port(field string, value uint) {
+	v.check(value > 0 && value <= 65535, field, "must be between 1 and 65535, got %d", value)
+}
+
+func (v *validator) oneOf(field, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.check(false, field, "must be one of %s, got %q", strings.Join(allowed, ", "), value)
+}
+
+func validURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
+
+const minSecretKeyLength = 16
+
+// Validate reports every invalid field at once
+func (c Config) Validate() error {
+	var v validator
+
+	v.port("server.port", c.Server.Port)
+	v.check(c.Server.RequestTimeout >= 0, "server.requesttimeout", "must not be negative")
+	v.check(c.Server.HealthTimeout >= 0, "server.healthtimeout", "must not be negative")
+	v.check(c.Server.ShutdownDelay >= 0, "server.shutdowndelay", "must not be negative")
+	v.check(c.Server.ShutdownTimeout >= 0, "server.shutdowntimeout", "must not be negative")
+	for _, proxy := range c.Server.TrustedProxies {
+		_, _, err := net.ParseCIDR(proxy)
+		v.check(err == nil, "server.trustedproxies", "%q is not a CIDR", proxy)
+	}
+
+	v.required("databasepostgres.host", c.Databasepostgres.Host)
+	v.port("databasepostgres.port", c.Databasepostgres.Port)
+	v.required("databasepostgres.username", c.Databasepostgres.Username)
+	v.required("databasepostgres.dbname", c.Databasepostgres.DBName)
+	v.oneOf("databasepostgres.sslmode", c.Databasepostgres.SSLMode, "disable", "allow", "prefer", "require", "verify-ca", "verify-full")
+
+	v.required("redis.host", c.Redis.Host)
+	v.port("redis.port", c.Redis.Port)
+
+	v.check(len(c.JWT.SecretKey) >= minSecretKeyLength, "jwt.secretkey", "must be at least %d characters", minSecretKeyLength)
+	v.check(c.JWT.AccessTokenTTL > 0, "jwt.accesstokenttl", "must be positive")
+	v.check(c.JWT.RefreshTokenTTL >= c.JWT.AccessTokenTTL, "jwt.refreshtokenttl", "must not be shorter than jwt.accesstokenttl")
+	v.check(c.JWT.MaxSessionLifetime >= 0, "jwt.maxsessionlifetime", "must not be negative")
+
+	if c.Tracing.Enabled {
+		v.required("tracing.servicename", c.Tracing.ServiceName)
+		v.oneOf("tracing.exporter", c.Tracing.Exporter, "otlp", "stdout")
+		if c.Tracing.Exporter == "otlp" {
+			v.required("tracing.endpoint", c.Tracing.Endpoint)
+		}
+		v.check(c.Tracing.SampleRatio > 0 && c.Tracing.SampleRatio <= 1, "tracing.sampleratio", "must be in (0, 1], got %g", c.Tracing.SampleRatio)
+	}
+
+	v.check(len(c.CORS.AllowOrigins) > 0, "cors.alloworigins", "must list at least one origin")
+	for _, origin := range c.CORS.AllowOrigins {
+		v.check(utils.ValidOriginPattern(origin), "cors.alloworigins", "%q is not an origin or wildcard origin pattern", origin)
+		v.check(!(origin == "*" && c.CORS.AllowCredentials), "cors.alloworigins", `"*" cannot be combined with cors.allowcredentials`)
+	}
+	for _, header := range c.CORS.AllowHeaders {
+		v.check(!(header == "*" && c.CORS.AllowCredentials), "cors.allowheaders", `"*" cannot be combined with cors.allowcredentials`)
+	}
+	for _, method := range c.CORS.AllowMethods {
+		v.oneOf("cors.allowmethods", method, "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS")
+	}
+	v.check(c.CORS.MaxAge >= 0, "cors.maxage", "must not be negative")
+
+	for name, policy := range c.RateLimit.Policies {
+		v.check(policy.Limit > 0, "ratelimit.policies."+name+".limit", "must be positive")
+		v.check(policy.Window > 0, "ratelimit.policies."+name+".window", "must be positive")
+	}
+
+	for name, provider := range c.OIDC.Providers {
+		field := "oidc.providers." + name
+		v.check(validURL(provider.IssuerURL), field+".issuerurl", "must be an absolute URL")
+		v.required(field+".clientid", provider.ClientID)
+		v.check(validURL(provider.RedirectURL), field+".redirecturl", "must be an absolute URL")
+	}
+
+	v.oneOf("mail.driver", c.Mail.Driver, "smtp", "log")
+	if c.Mail.Driver == "smtp" {
+		v.required("mail.host", c.Mail.Host)
+		v.port("mail.port", c.Mail.Port)
+		v.required("mail.from", c.Mail.From)
+	}
+
+	v.check(validURL(c.MagicLink.URL), "magiclink.url", "must be an absolute URL")
+	v.check(c.MagicLink.TTL > 0, "magiclink.ttl", "must be positive")
+
+	v.check(validURL(c.PasswordReset.URL), "passwordreset.url", "must be an absolute URL")
+	v.check(c.PasswordReset.TTL > 0, "passwordreset.ttl", "must be positive")
+
+	v.required("webauthn.rpid", c.WebAuthn.RPID)
+	v.required("webauthn.rpdisplayname", c.WebAuthn.RPDisplayName)
+	v.check(len(c.WebAuthn.RPOrigins) > 0, "webauthn.rporigins", "must list at least one origin")
+	for _, origin := range c.WebAuthn.RPOrigins {
+		v.check(validURL(origin), "webauthn.rporigins", "%q is not an origin", origin)
+	}
+
+	v.oneOf("password.algorithm", c.Password.Algorithm, "argon2id", "bcrypt")
+	argon := c.Password.Argon2id
+	v.check(argon.Parallelism >= 1 && argon.Parallelism <= 255, "password.argon2id.parallelism", "must be between 1 and 255")
+	v.check(argon.Memory >= 8*argon.Parallelism && argon.Memory <= 1<<22, "password.argon2id.memory", "must be between 8*parallelism and 4194304 KiB")
+	v.check(argon.Iterations >= 1, "password.argon2id.iterations", "must be positive")
+	v.check(argon.SaltLength >= 16, "password.argon2id.saltlength", "must be at least 16 bytes")
+	v.check(argon.KeyLength >= 16, "password.argon2id.keylength", "must be at least 16 bytes")
+	v.check(c.Password.Policy.MinLength >= 8, "password.policy.minlength", "must be at least 8")
+	v.check(c.Password.Policy.MinEntropy >= 0, "password.policy.minentropy", "must not be negative")
+	v.check(c.Password.Policy.HistorySize <= 24, "password.policy.historysize", "must be at most 24")
+	v.check(c.Password.Policy.MaxAge >= 0, "password.policy.maxage", "must not be negative")
+	v.check(c.Password.BcryptCost >= 10 && c.Password.BcryptCost <= 31, "password.bcryptcost", "must be between 10 and 31")
+
+	v.check(c.Retention.DeletedUsers >= 0, "retention.deletedusers", "must not be negative")
+	v.check(c.Retention.PurgeInterval > 0, "retention.purgeinterval", "must be positive")
+
+	v.check(c.Export.InlineWait >= 0, "export.inlinewait", "must not be negative")
+	v.check(c.Export.ArchiveTTL > 0, "export.archivettl", "must be positive")
+
+	v.oneOf("log.level", c.Log.Level, "debug", "info", "warn", "error", "off")
+
+	if len(v.problems) > 0 {
+		return &ValidationError{Problems: v.problems}
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package config
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/labstack/gommon/log"
+	"github.com/spf13/viper"
+)
+
+// reloadableFields can change at runtime; a change to any other field is rejected until restart
+var reloadableFields = map[string]bool{
+	"log.level":                   true,
+	"jwt.accesstokenttl":          true,
+	"jwt.refreshtokenttl":         true,
+	"jwt.slidingsession":          true,
+	"jwt.maxsessionlifetime":      true,
+	"cors.alloworigins":           true,
+	"ratelimit.enabled":           true,
+	"ratelimit.policies":          true,
+	"magiclink.ttl":               true,
+	"passwordreset.ttl":           true,
+	"password.policy.historysize": true,
+	"password.policy.maxage":      true,
+	"retention.deletedusers":      true,
+	"export.inlinewait":           true,
+	"export.archivettl":           true,
+}
+
+// Watcher holds the live configuration and notifies subscribers when the config file changes
+type Watcher struct {
+	mu          sync.RWMutex
+	current     Config
+	subscribers []func(Config)
+}
+
+func NewWatcher(initial Config) *Watcher {
+	return &Watcher{current: initial}
+}
+
+// Current returns the configuration with every accepted reload applied
+func (w *Watcher) Current() Config {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.current
+}
+
+// Subscribe registers fn to be called with the new configuration after each accepted reload
+func (w *Watcher) Subscribe(fn func(Config)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.subscribers = append(w.subscribers, fn)
+}
+
+// Start watches the config file read by LoadConfig and the environment profile merged over it.
+// A change to either one reads both again, so the profile keeps taking precedence.
+func (w *Watcher) Start() {
+	fileWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Errorf("config reload disabled: %v", err)
+		return
+	}
+
+	// viper reports the base file by its absolute path and the profile path is relative, so compare absolute paths
+	files := make(map[string]bool)
+	for _, file := range []string{viper.ConfigFileUsed(), profilePath(viper.GetString("environment"))} {
+		if file == "" {
+			continue
+		}
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			log.Errorf("config reload disabled: %v", err)
+			fileWatcher.Close()
+			return
+		}
+		files[abs] = true
+	}
+
+	// Watch the directories rather than the files, since editors often replace a file instead of writing it
+	dirs := make(map[string]bool)
+	for file := range files {
+		dir := filepath.Dir(file)
+		if dirs[dir] {
+			continue
+		}
+		if err := fileWatcher.Add(dir); err != nil {
+			log.Errorf("config reload disabled: %v", err)
+			fileWatcher.Close()
+			return
+		}
+		dirs[dir] = true
+	}
+
+	go func() {
+		defer fileWatcher.Close()
+		for {
+			select {
+			case event, ok := <-fileWatcher.Events:
+				if !ok {
+					return
+				}
+				name, _ := filepath.Abs(event.Name)
+				if files[name] && event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) != 0 {
+					w.reload()
+				}
+			case err, ok := <-fileWatcher.Errors:
+				if !ok {
+					return
+				}
+				log.Errorf("config watch failed: %v", err)
+			}
+		}
+	}()
+}
+
+func (w *Watcher) reload() {
+	err := readConfig()
+	if err != nil {
+		log.Errorf("config reload rejected: %v", err)
+		return
+	}
+
+	next, err := load()
+	if err != nil {
+		log.Errorf("config reload rejected: %v", err)
+		return
+	}
+
+	w.mu.Lock()
+	applied, rejected := mergeReloadable(w.current, next)
+	changed := !reflect.DeepEqual(w.current, applied)
+	w.current = applied
+	subscribers := append([]func(Config){}, w.subscribers...)
+	w.mu.Unlock()
+
+	if len(rejected) > 0 {
+		log.Warnf("config changes require a restart and were not applied: %s", strings.Join(rejected, ", "))
+	}
+	if !changed {
+		return
+	}
+
+	log.Info("config reloaded")
+	for _, fn := range subscribers {
+		fn(applied)
+	}
+}
+
+// mergeReloadable copies reloadable fields from next onto current and lists every other field that differs
+func mergeReloadable(current, next Config) (Config, []string) {
+	merged := current
+	var rejected []string
+	walkFields(reflect.ValueOf(&merged).Elem(), reflect.ValueOf(next), "", func(path string, dst, src reflect.Value) {
+		if reflect.DeepEqual(dst.Interface(), src.Interface()) {
+			return
+		}
+		if reloadableFields[path] {
+			dst.Set(src)
+			return
+		}
+		rejected = append(rejected, path)
+	})
+	return merged, rejected
+}
+
+// walkFields visits leaf fields of two structs of the same type, naming them by their mapstructure tags
+func walkFields(dst, src reflect.Value, prefix string, visit func(path string, dst, src reflect.Value)) {
+	for i := 0; i < dst.NumField(); i++ {
+		field := dst.Type().Field(i)
+		path := prefix + field.Tag.Get("mapstructure")
+		if field.Type.Kind() == reflect.Struct && !reloadableFields[path] {
+			walkFields(dst.Field(i), src.Field(i), path+".", visit)
+			continue
+		}
+		visit(path, dst.Field(i), src.Field(i))
+	}
+}
@@ -0,0 +1,19 @@
+package mailer
+
+import (
+	"context"
+
+	"github.com/labstack/gommon/log"
+)
+
+type logMailer struct{}
+
+// NewLogMailer writes messages to the log instead of sending them. Use it in development only.
+func NewLogMailer() Mailer {
+	return logMailer{}
+}
+
+func (logMailer) Send(ctx context.Context, msg Message) error {
+	log.Infof("mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+type redisStartTimeKey struct{}
+
+// RedisHook records latencies for every Redis command
+type RedisHook struct{}
+
+func (RedisHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisStartTimeKey{}, time.Now()), nil
+}
+
+func (RedisHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if start, ok := ctx.Value(redisStartTimeKey{}).(time.Time); ok {
+		observeRedis(cmd, time.Since(start))
+	}
+	return nil
+}
+
+func (RedisHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisStartTimeKey{}, time.Now()), nil
+}
+
+func (RedisHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	if start, ok := ctx.Value(redisStartTimeKey{}).(time.Time); ok {
+		elapsed := time.Since(start)
+		for _, cmd := range cmds {
+			observeRedis(cmd, elapsed)
+		}
+	}
+	return nil
+}
+
+func observeRedis(cmd redis.Cmder, elapsed time.Duration) {
+	status := "ok"
+	if err := cmd.Err(); err != nil && err != redis.Nil {
+		status = "error"
+	}
+	RedisCommandDuration.WithLabelValues(cmd.Name(), status).Observe(elapsed.Seconds())
+}
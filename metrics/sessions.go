@@ -0,0 +1,26 @@
+package metrics
+
+import (
+	"context"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/gommon/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.learning/utils"
+)
+
+// RegisterActiveSessions exposes the number of live sessions in the Redis session store
+func RegisterActiveSessions(redisClient *redis.Client) error {
+	return prometheus.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_sessions",
+		Help:      "Number of active sessions in the session store.",
+	}, func() float64 {
+		count, err := utils.CountActiveSessions(context.Background(), redisClient)
+		if err != nil {
+			log.Errorf("failed to count active sessions: %v", err)
+			return 0
+		}
+		return float64(count)
+	}))
+}
@@ -0,0 +1,130 @@
+package middlewares
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"go.learning/models"
+	"go.learning/utils"
+)
+
+// APIKeyHeader carries API keys for service-to-service calls
+const APIKeyHeader = "X-API-Key"
+
+// APIKeyAuthenticator resolves a raw API key to the stored key it belongs to
+type APIKeyAuthenticator interface {
+	Authenticate(ctx context.Context, rawKey string) (*models.APIKey, error)
+}
+
+// AdminChecker reports whether a user has the admin role
+type AdminChecker interface {
+	IsAdmin(ctx context.Context, userID uint) (bool, error)
+}
+
+type AuthMiddleware interface {
+	Authenticate() echo.MiddlewareFunc
+	RequireScope(scope string) echo.MiddlewareFunc
+	RequireAdmin() echo.MiddlewareFunc
+}
+
+type authMiddleware struct {
+	tokenAuth TokenAuthMiddleware
+	apiKeys   APIKeyAuthenticator
+	admins    AdminChecker
+}
+
+func NewAuthMiddleware(tokenAuth TokenAuthMiddleware, apiKeys APIKeyAuthenticator, admins AdminChecker) AuthMiddleware {
+	return authMiddleware{tokenAuth, apiKeys, admins}
+}
+
+// Authenticate accepts either an API key in the X-API-Key header or a bearer JWT
+func (m authMiddleware) Authenticate() echo.MiddlewareFunc {
+	tokenAuth := m.tokenAuth.AllowDelegated()
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		withToken := tokenAuth(next)
+		return func(c echo.Context) error {
+			rawKey := c.Request().Header.Get(APIKeyHeader)
+			if rawKey == "" {
+				return withToken(c)
+			}
+
+			key, err := m.apiKeys.Authenticate(c.Request().Context(), rawKey)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid or expired API key")
+			}
+
+			// Act as the key's owner, limited to the key's scopes
+			c.Set("userID", fmt.Sprintf("%d", key.UserID))
+			c.Set("apiKey", key)
+
+			return next(c)
+		}
+	}
+}
+
+// RequireScope rejects API keys and OAuth tokens that were not granted scope. User tokens carry the user's
+// full access; tokens issued to OAuth clients for themselves belong to no user and are rejected.
+func (m authMiddleware) RequireScope(scope string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if key, ok := c.Get("apiKey").(*models.APIKey); ok && !key.HasScope(scope) {
+				return echo.NewHTTPError(http.StatusForbidden, "API key is missing scope "+scope)
+			}
+			if grant, ok := c.Get("oauthGrant").(*utils.OAuthGrant); ok && !grant.HasScope(scope) {
+				return echo.NewHTTPError(http.StatusForbidden, "Token was not granted scope "+scope)
+			}
+			if _, ok := CurrentUserID(c); !ok {
+				return echo.NewHTTPError(http.StatusForbidden, "Token does not belong to a user")
+			}
+			return next(c)
+		}
+	}
+}
+
+// RequireAdmin rejects callers whose user is not an admin. The role is looked up on every request so demotions apply at once.
+func (m authMiddleware) RequireAdmin() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			userID, ok := CurrentUserID(c)
+			if !ok {
+				return echo.NewHTTPError(http.StatusForbidden, "Token does not belong to a user")
+			}
+			admin, err := m.admins.IsAdmin(c.Request().Context(), userID)
+			if err != nil {
+				return err
+			}
+			if !admin {
+				return echo.NewHTTPError(http.StatusForbidden, "Admin role required")
+			}
+			return next(c)
+		}
+	}
+}
+
+// HasScope reports whether the caller may use scope: API keys and OAuth tokens need it granted,
+// user tokens carry the user's full access
+func HasScope(c echo.Context, scope string) bool {
+	if key, ok := c.Get("apiKey").(*models.APIKey); ok {
+		return key.HasScope(scope)
+	}
+	if grant, ok := c.Get("oauthGrant").(*utils.OAuthGrant); ok {
+		return grant.HasScope(scope)
+	}
+	return true
+}
+
+// CurrentUserID returns the authenticated user's ID set by the auth middlewares
+func CurrentUserID(c echo.Context) (uint, bool) {
+	userID, ok := c.Get("userID").(string)
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(userID, 10, 0)
+	if err != nil {
+		return 0, false
+	}
+	return uint(id), true
+}
@@ -0,0 +1,24 @@
+package middlewares
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"go.learning/config"
+	"go.learning/utils"
+)
+
+// CORSMiddleware applies the configured CORS policy. Allowed origins are read on every
+// request so they follow config reloads; the remaining settings are fixed at startup.
+func CORSMiddleware(watcher *config.Watcher) echo.MiddlewareFunc {
+	cors := watcher.Current().CORS
+	return middleware.CORSWithConfig(middleware.CORSConfig{
+		AllowOriginFunc: func(origin string) (bool, error) {
+			return utils.MatchOrigin(watcher.Current().CORS.AllowOrigins, origin), nil
+		},
+		AllowMethods:     cors.AllowMethods,
+		AllowHeaders:     cors.AllowHeaders,
+		ExposeHeaders:    cors.ExposeHeaders,
+		AllowCredentials: cors.AllowCredentials,
+		MaxAge:           int(cors.MaxAge.Seconds()),
+	})
+}
@@ -0,0 +1,92 @@
+package middlewares
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"go.learning/config"
+	"go.learning/models"
+	"go.learning/ratelimit"
+)
+
+// RateLimitKeyFunc identifies the client a request is counted against
+type RateLimitKeyFunc func(c echo.Context) string
+
+// RateLimitByIP counts requests per client IP
+func RateLimitByIP(c echo.Context) string {
+	return "ip:" + c.RealIP()
+}
+
+// RateLimitByUser counts requests per authenticated user, falling back to the client IP.
+// It must run after the middleware that sets userID.
+func RateLimitByUser(c echo.Context) string {
+	if userID := c.Get("userID"); userID != nil {
+		return fmt.Sprintf("user:%v", userID)
+	}
+	return RateLimitByIP(c)
+}
+
+// RateLimitByAPIKey counts requests made with an API key per key, so a busy key doesn't use up its owner's
+// quota, and other requests per user. It must run after the middleware that authenticates the key.
+func RateLimitByAPIKey(c echo.Context) string {
+	if key, ok := c.Get("apiKey").(*models.APIKey); ok {
+		return fmt.Sprintf("apikey:%d", key.ID)
+	}
+	return RateLimitByUser(c)
+}
+
+type RateLimitMiddleware interface {
+	Limit(policy string, key RateLimitKeyFunc) echo.MiddlewareFunc
+}
+
+type rateLimitMiddleware struct {
+	limiter ratelimit.Limiter
+	watcher *config.Watcher
+}
+
+func NewRateLimitMiddleware(limiter ratelimit.Limiter, watcher *config.Watcher) RateLimitMiddleware {
+	return rateLimitMiddleware{limiter, watcher}
+}
+
+// Limit applies the named policy from config.RateLimit. Limits are read per request so they follow config reloads.
+func (m rateLimitMiddleware) Limit(policy string, key RateLimitKeyFunc) echo.MiddlewareFunc {
+	if _, ok := m.watcher.Current().RateLimit.Policies[policy]; !ok {
+		log.Warnf("rate limit policy %q is not configured; requests will not be limited", policy)
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			cfg := m.watcher.Current().RateLimit
+			p, ok := cfg.Policies[policy]
+			if !cfg.Enabled || !ok {
+				return next(c)
+			}
+
+			result, err := m.limiter.Allow(c.Request().Context(), policy+":"+key(c), p.Limit, p.Window)
+			if err != nil {
+				// Fail open so a limiter outage doesn't take the API down with it
+				log.Errorf("rate limit check failed: %v", err)
+				return next(c)
+			}
+
+			reset := int(math.Ceil(result.ResetAfter.Seconds()))
+			header := c.Response().Header()
+			header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+			header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			header.Set("RateLimit-Reset", strconv.Itoa(reset))
+			header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", p.Limit, int(p.Window/time.Second)))
+
+			if !result.Allowed {
+				header.Set("Retry-After", strconv.Itoa(reset))
+				return echo.NewHTTPError(http.StatusTooManyRequests, "Rate limit exceeded")
+			}
+
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/labstack/gommon/log"
+	"go.learning/models"
+)
+
+// RequestLogger stores request log entries
+type RequestLogger interface {
+	LogRequest(ctx context.Context, entry *models.Logger) error
+}
+
+// RequestLogMiddleware stores a log entry per request, attributed to the authenticated user if there is one.
+// Auth runs per route, so the user is only known once the handler chain returns.
+func RequestLogMiddleware(logger RequestLogger, skipper middleware.Skipper) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if skipper(c) {
+				return next(c)
+			}
+
+			start := time.Now()
+			err := next(c)
+			latency := time.Since(start)
+
+			// Errors are rendered after the middleware chain returns, so resolve the status here
+			req := c.Request()
+			entry := &models.Logger{
+				Time:         start,
+				RemoteIP:     truncate(c.RealIP(), 45),
+				Host:         truncate(req.Host, 255),
+				Method:       req.Method,
+				URI:          truncate(req.RequestURI, 2048),
+				UserAgent:    truncate(req.UserAgent(), 255),
+				Status:       c.Response().Status,
+				Latency:      latency.Microseconds(),
+				LatencyHuman: latency.String(),
+				BytesOut:     c.Response().Size,
+			}
+			if err != nil {
+				entry.Error = truncate(err.Error(), 255)
+				var httpErr *echo.HTTPError
+				if errors.As(err, &httpErr) {
+					entry.Status = httpErr.Code
+				} else {
+					entry.Status = http.StatusInternalServerError
+				}
+			}
+			if contentLength, parseErr := strconv.ParseInt(req.Header.Get(echo.HeaderContentLength), 10, 64); parseErr == nil {
+				entry.BytesIn = contentLength
+			}
+			if userID, ok := CurrentUserID(c); ok {
+				entry.UserID = &userID
+			}
+
+			if logErr := logger.LogRequest(req.Context(), entry); logErr != nil {
+				log.Errorf("failed to store request log: %v", logErr)
+			}
+			return err
+		}
+	}
+}
+
+// truncate cuts value to fit a column of max bytes without splitting a character
+func truncate(value string, max int) string {
+	if len(value) > max {
+		return strings.ToValidUTF8(value[:max], "")
+	}
+	return value
+}
@@ -0,0 +1,33 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// TimeoutMiddleware bounds the request context so DB and Redis work is cancelled once the deadline passes.
+// Requests matched by skipper, such as streamed downloads, are not bounded.
+func TimeoutMiddleware(timeout time.Duration, skipper middleware.Skipper) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if timeout <= 0 || skipper(c) {
+				return next(c)
+			}
+
+			ctx, cancel := context.WithTimeout(c.Request().Context(), timeout)
+			defer cancel()
+			c.SetRequest(c.Request().WithContext(ctx))
+
+			err := next(c)
+			if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return echo.NewHTTPError(http.StatusServiceUnavailable, "Request timed out")
+			}
+			return err
+		}
+	}
+}
@@ -7,10 +7,13 @@ import (
 	"github.com/dgrijalva/jwt-go"
 	"github.com/go-redis/redis/v8"
 	"github.com/labstack/echo/v4"
+	"go.learning/utils"
 )
 
 type TokenAuthMiddleware interface {
 	TokenAuthMiddleware() echo.MiddlewareFunc
+	AllowRestricted(scope string) echo.MiddlewareFunc
+	AllowDelegated() echo.MiddlewareFunc
 }
 
 type tokenAuthMiddleware struct {
@@ -22,8 +25,23 @@ func NewTokenAuthMiddleware(redisClient *redis.Client, jwt_secret_key string) To
 	return tokenAuthMiddleware{redisClient, jwt_secret_key}
 }
 
-// Middleware to validate JWT token
+// Middleware to validate JWT token. Restricted tokens and tokens issued to OAuth clients are rejected.
 func (m tokenAuthMiddleware) TokenAuthMiddleware() echo.MiddlewareFunc {
+	return m.authenticate("", false)
+}
+
+// AllowRestricted validates JWT tokens like TokenAuthMiddleware and also accepts tokens restricted to scope
+func (m tokenAuthMiddleware) AllowRestricted(scope string) echo.MiddlewareFunc {
+	return m.authenticate(scope, false)
+}
+
+// AllowDelegated validates JWT tokens like TokenAuthMiddleware and also accepts tokens issued to OAuth clients.
+// Their grant is set in the context so RequireScope can limit them to the granted scopes.
+func (m tokenAuthMiddleware) AllowDelegated() echo.MiddlewareFunc {
+	return m.authenticate("", true)
+}
+
+func (m tokenAuthMiddleware) authenticate(allowedScope string, allowDelegated bool) echo.MiddlewareFunc {
 	var jwtSecretKey = []byte(m.jwt_secret_key)
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
@@ -63,7 +81,7 @@ func (m tokenAuthMiddleware) TokenAuthMiddleware() echo.MiddlewareFunc {
 				}
 
 				// Check sessionID in Redis
-				storedToken, err := m.redisClient.Get(c.Request().Context(), sessionID).Result()
+				storedToken, err := utils.CheckSessionInRedis(c.Request().Context(), m.redisClient, sessionID)
 				if err == redis.Nil {
 					return echo.NewHTTPError(http.StatusUnauthorized, "Session ID is invalid or expired")
 				} else if err != nil {
@@ -75,6 +93,26 @@ func (m tokenAuthMiddleware) TokenAuthMiddleware() echo.MiddlewareFunc {
 					return echo.NewHTTPError(http.StatusUnauthorized, "Token does not match session")
 				}
 
+				// Restricted tokens only work on routes that allow their scope
+				if scope, restricted := claims["scope"].(string); restricted && scope != allowedScope {
+					if scope == utils.ScopePasswordChange {
+						return echo.NewHTTPError(http.StatusForbidden, "Password change required")
+					}
+					return echo.NewHTTPError(http.StatusForbidden, "Token is not valid for this endpoint")
+				}
+
+				// Tokens issued to OAuth clients only work where their grant's scopes are checked
+				grant, err := utils.GetOAuthGrant(c.Request().Context(), m.redisClient, sessionID)
+				if err != nil {
+					return echo.NewHTTPError(http.StatusInternalServerError, "Failed to validate session ID")
+				}
+				if grant != nil {
+					if !allowDelegated {
+						return echo.NewHTTPError(http.StatusForbidden, "Token issued to an OAuth client is not valid for this endpoint")
+					}
+					c.Set("oauthGrant", grant)
+				}
+
 				// Set userID in the context for later use
 				c.Set("userID", claims["userID"])
 			} else {
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+type APIKey struct {
+	ID         uint       `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID     uint       `gorm:"index;not null" json:"user_id"` // owner who created the key
+	User       User       `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	Name       string     `gorm:"size:100;not null" json:"name"`
+	Prefix     string     `gorm:"uniqueIndex;size:16;not null" json:"prefix"` // public lookup part of the key
+	HashedKey  string     `gorm:"size:64;not null" json:"-"`                  // SHA-256 of the full key
+	Scopes     string     `gorm:"size:1024" json:"scopes"`                    // space separated
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt  time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+func (k APIKey) ScopeList() []string {
+	return strings.Fields(k.Scopes)
+}
+
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.ScopeList() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Audited actions on a user's account
+const (
+	AuditSessionCreated  = "session_created"
+	AuditLoginFailed     = "login_failed"
+	AuditPasswordChanged = "password_changed"
+	AuditPasswordReset   = "password_reset"
+	AuditProfileUpdated  = "profile_updated"
+	AuditUserDeleted     = "user_deleted"
+	AuditUserRestored    = "user_restored"
+	AuditUserErased      = "user_erased"
+)
+
+// AuditEvent records a security relevant event on a user's account
+type AuditEvent struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID    uint      `gorm:"index;not null" json:"user_id"`
+	Action    string    `gorm:"size:64;not null" json:"action"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
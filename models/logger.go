@@ -6,6 +6,7 @@ import (
 
 type Logger struct {
 	ID           uint      `gorm:"primaryKey;autoIncrement" json:"id"` // Primary key
+	UserID       *uint     `gorm:"index" json:"user_id"`               // Authenticated user, if any
 	Time         time.Time `gorm:"not null" json:"time"`
 	RemoteIP     string    `gorm:"size:45;not null" json:"remote_ip"` // Supports IPv6
 	Host         string    `gorm:"size:255;not null" json:"host"`
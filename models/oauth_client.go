@@ -0,0 +1,47 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// OAuthClient is an application that delegates login to this service through OAuth2
+type OAuthClient struct {
+	ID           uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID       uint      `gorm:"index;not null" json:"user_id"` // owner who registered the client
+	User         User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	Name         string    `gorm:"size:100;not null" json:"name"`
+	ClientID     string    `gorm:"uniqueIndex;size:32;not null" json:"client_id"`
+	HashedSecret string    `gorm:"size:64;not null" json:"-"`      // SHA-256 of the client secret
+	RedirectURIs string    `gorm:"size:2048" json:"redirect_uris"` // space separated
+	Scopes       string    `gorm:"size:1024" json:"scopes"`        // space separated
+	CreatedAt    time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt    time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+func (c OAuthClient) RedirectURIList() []string {
+	return strings.Fields(c.RedirectURIs)
+}
+
+func (c OAuthClient) ScopeList() []string {
+	return strings.Fields(c.Scopes)
+}
+
+// AllowsRedirectURI reports whether uri exactly matches a registered redirect URI
+func (c OAuthClient) AllowsRedirectURI(uri string) bool {
+	for _, registered := range c.RedirectURIList() {
+		if registered == uri {
+			return true
+		}
+	}
+	return false
+}
+
+func (c OAuthClient) HasScope(scope string) bool {
+	for _, s := range c.ScopeList() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// PasswordHistory keeps a user's previous password hashes so they can't be reused
+type PasswordHistory struct {
+	ID             uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID         uint      `gorm:"index;not null" json:"user_id"`
+	User           User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	HashedPassword string    `gorm:"size:255;not null" json:"-"`
+	CreatedAt      time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
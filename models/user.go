@@ -6,14 +6,24 @@ import (
 	"gorm.io/gorm"
 )
 
+// Roles a user can have
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 type User struct {
-	ID             uint           `gorm:"primaryKey;autoIncrement" json:"id"`
-	Email          string         `gorm:"uniqueIndex;size:255;not null" json:"email"`
-	FirstName      string         `gorm:"size:100;not null" json:"first_name"`
-	LastName       string         `gorm:"size:100;not null" json:"last_name"`
-	HashedPassword string         `gorm:"size:255;not null" json:"-"`
-	Active         bool           `gorm:"default:true" json:"active"`
-	CreatedAt      time.Time      `gorm:"autoCreateTime" json:"created_at"`
-	UpdatedAt      time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
-	DeletedAt      gorm.DeletedAt `gorm:"index" json:"-"` // soft delete
+	ID                uint           `gorm:"primaryKey;autoIncrement" json:"id"`
+	Email             string         `gorm:"uniqueIndex:idx_users_email_active,where:deleted_at IS NULL;size:255;not null" json:"email"` // deleted users don't block re-registration
+	FirstName         string         `gorm:"size:100;not null" json:"first_name"`
+	LastName          string         `gorm:"size:100;not null" json:"last_name"`
+	HashedPassword    string         `gorm:"size:255;not null" json:"-"`
+	PasswordChangedAt *time.Time     `json:"password_changed_at"` // nil for accounts created before password expiry or without a password
+	Active            bool           `gorm:"default:true" json:"active"`
+	Role              string         `gorm:"size:20;not null;default:user" json:"role"`
+	Version           uint           `gorm:"not null;default:1" json:"-"` // bumped on every profile change, exposed as the ETag
+	CreatedAt         time.Time      `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt         time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
+	DeletedAt         gorm.DeletedAt `gorm:"index" json:"-"` // soft delete
+	AnonymizedAt      *time.Time     `json:"-"`              // PII was erased; the row only remains for references to it
 }
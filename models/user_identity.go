@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// UserIdentity links a user to an account at an external OpenID Connect provider
+type UserIdentity struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID    uint      `gorm:"index;not null" json:"user_id"`
+	User      User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	Provider  string    `gorm:"uniqueIndex:idx_user_identities_provider_subject;size:50;not null" json:"provider"`
+	Subject   string    `gorm:"uniqueIndex:idx_user_identities_provider_subject;size:255;not null" json:"subject"` // "sub" claim at the provider
+	Email     string    `gorm:"size:255" json:"email"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
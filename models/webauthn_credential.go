@@ -0,0 +1,29 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// WebAuthnCredential is a passkey registered by a user; a user can have several
+type WebAuthnCredential struct {
+	ID              uint       `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID          uint       `gorm:"index;not null" json:"user_id"`
+	User            User       `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	Name            string     `gorm:"size:100" json:"name"`
+	CredentialID    []byte     `gorm:"uniqueIndex;not null" json:"-"`
+	PublicKey       []byte     `gorm:"not null" json:"-"` // COSE encoded
+	AttestationType string     `gorm:"size:32" json:"attestation_type"`
+	Transports      string     `gorm:"size:255" json:"transports"` // space separated
+	AAGUID          []byte     `json:"-"`
+	SignCount       uint32     `gorm:"not null;default:0" json:"sign_count"`
+	BackupEligible  bool       `json:"backup_eligible"`
+	BackupState     bool       `json:"backup_state"`
+	LastUsedAt      *time.Time `json:"last_used_at"`
+	CreatedAt       time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt       time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+func (c WebAuthnCredential) TransportList() []string {
+	return strings.Fields(c.Transports)
+}
@@ -0,0 +1,186 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testParams keep hashing fast; the encoding doesn't depend on the cost
+var testParams = Argon2idParams{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestArgon2idHashEncoding(t *testing.T) {
+	a := NewArgon2id(testParams)
+
+	encoded, err := a.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("Hash = %q, want the PHC prefix with the configured parameters", encoded)
+	}
+	if !a.Recognizes(encoded) {
+		t.Errorf("Recognizes(%q) = false", encoded)
+	}
+
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		t.Fatalf("decodeArgon2id: %v", err)
+	}
+	if params != testParams {
+		t.Errorf("decoded params = %+v, want %+v", params, testParams)
+	}
+	if len(salt) != int(testParams.SaltLength) || len(key) != int(testParams.KeyLength) {
+		t.Errorf("decoded salt and key are %d and %d bytes, want %d and %d", len(salt), len(key), testParams.SaltLength, testParams.KeyLength)
+	}
+
+	again, err := a.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if again == encoded {
+		t.Error("two hashes of the same password are equal, the salt is not random")
+	}
+}
+
+func TestArgon2idDecodeInvalid(t *testing.T) {
+	valid, err := NewArgon2id(testParams).Hash("password")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	parts := strings.Split(valid, "$")
+
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{"empty", ""},
+		{"bcrypt", "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy"},
+		{"missing key", strings.Join(parts[:5], "$")},
+		{"extra part", valid + "$x"},
+		{"other variant", strings.Replace(valid, "$argon2id$", "$argon2i$", 1)},
+		{"other version", strings.Replace(valid, "v=19", "v=16", 1)},
+		{"malformed version", strings.Replace(valid, "v=19", "v19", 1)},
+		{"malformed params", strings.Replace(valid, "m=64,t=1,p=1", "m=64;t=1;p=1", 1)},
+		{"bad salt", strings.Join([]string{parts[0], parts[1], parts[2], parts[3], "!!", parts[5]}, "$")},
+		{"bad key", strings.Join([]string{parts[0], parts[1], parts[2], parts[3], parts[4], "!!"}, "$")},
+		{"empty key", strings.Join([]string{parts[0], parts[1], parts[2], parts[3], parts[4], ""}, "$")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, _, err := decodeArgon2id(tt.encoded); !errors.Is(err, ErrInvalidHash) {
+				t.Errorf("decodeArgon2id(%q) error = %v, want ErrInvalidHash", tt.encoded, err)
+			}
+		})
+	}
+}
+
+func TestArgon2idVerify(t *testing.T) {
+	a := NewArgon2id(testParams)
+	encoded, err := a.Hash("s3cret-Passw0rd")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		encoded  string
+		want     bool
+		wantErr  error
+	}{
+		{"matching password", "s3cret-Passw0rd", encoded, true, nil},
+		{"wrong password", "s3cret-Passw0rd!", encoded, false, nil},
+		{"empty password", "", encoded, false, nil},
+		{"invalid hash", "s3cret-Passw0rd", "$argon2id$garbage", false, ErrInvalidHash},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := a.Verify(tt.password, tt.encoded)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Verify error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Verify = %t, want %t", got, tt.want)
+			}
+		})
+	}
+
+	// Hashes keep verifying with the parameters they were made with after the configuration changes
+	stronger := NewArgon2id(Argon2idParams{Memory: 128, Iterations: 2, Parallelism: 2, SaltLength: 16, KeyLength: 32})
+	if ok, err := stronger.Verify("s3cret-Passw0rd", encoded); err != nil || !ok {
+		t.Errorf("Verify with other parameters = %t, %v, want true", ok, err)
+	}
+}
+
+func TestArgon2idOutdated(t *testing.T) {
+	encoded, err := NewArgon2id(testParams).Hash("password")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		current Argon2idParams
+		encoded string
+		want    bool
+	}{
+		{"same parameters", testParams, encoded, false},
+		{"weaker parameters", Argon2idParams{Memory: 32, Iterations: 1, Parallelism: 1, SaltLength: 8, KeyLength: 16}, encoded, false},
+		{"more memory", Argon2idParams{Memory: 128, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}, encoded, true},
+		{"more iterations", Argon2idParams{Memory: 64, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32}, encoded, true},
+		{"other parallelism", Argon2idParams{Memory: 64, Iterations: 1, Parallelism: 2, SaltLength: 16, KeyLength: 32}, encoded, true},
+		{"longer salt", Argon2idParams{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 32, KeyLength: 32}, encoded, true},
+		{"longer key", Argon2idParams{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 64}, encoded, true},
+		{"invalid hash", testParams, "$argon2id$garbage", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewArgon2id(tt.current).Outdated(tt.encoded); got != tt.want {
+				t.Errorf("Outdated = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasherNeedsRehash(t *testing.T) {
+	argon := NewArgon2id(testParams)
+	bcrypt := NewBcrypt(4)
+
+	argonHash, err := argon.Hash("password")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	bcryptHash, err := bcrypt.Hash("password")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	stronger := NewArgon2id(Argon2idParams{Memory: 128, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+
+	tests := []struct {
+		name            string
+		hasher          Hasher
+		password        string
+		encoded         string
+		wantOK          bool
+		wantNeedsRehash bool
+	}{
+		{"current argon2id", NewHasher(argon, bcrypt), "password", argonHash, true, false},
+		{"outdated argon2id", NewHasher(stronger, bcrypt), "password", argonHash, true, true},
+		{"legacy bcrypt", NewHasher(argon, bcrypt), "password", bcryptHash, true, true},
+		{"wrong password", NewHasher(argon, bcrypt), "other", bcryptHash, false, false},
+		{"unknown algorithm", NewHasher(argon), "password", bcryptHash, false, false},
+		{"no password", NewHasher(argon, bcrypt), "password", "", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, needsRehash, err := tt.hasher.Verify(tt.password, tt.encoded)
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if ok != tt.wantOK || needsRehash != tt.wantNeedsRehash {
+				t.Errorf("Verify = %t, %t, want %t, %t", ok, needsRehash, tt.wantOK, tt.wantNeedsRehash)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package password
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BreachChecker reports whether a password appears in a known breach
+type BreachChecker interface {
+	Breached(ctx context.Context, password string) (bool, error)
+}
+
+// rangeDirectory reads an offline copy of the Pwned Passwords range dataset: one file per
+// 5-character SHA-1 prefix, named <PREFIX>.txt, with "SUFFIX:COUNT" lines. Like the online
+// k-anonymity API, only the prefix selects what is read, and the password never leaves the process.
+type rangeDirectory struct {
+	dir string
+}
+
+func NewRangeDirectory(dir string) (BreachChecker, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password dataset: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("breached password dataset %s is not a directory", dir)
+	}
+	return rangeDirectory{dir}, nil
+}
+
+func (r rangeDirectory) Breached(ctx context.Context, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	file, err := os.Open(filepath.Join(r.dir, prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read breached password dataset: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		line, _, _ := strings.Cut(scanner.Text(), ":")
+		if strings.EqualFold(strings.TrimSpace(line), suffix) {
+			return true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("failed to read breached password dataset: %w", err)
+	}
+	return false, nil
+}
@@ -0,0 +1,95 @@
+package password
+
+import (
+	"fmt"
+
+	"go.learning/config"
+)
+
+// Algorithm hashes passwords into a self-describing encoded string
+type Algorithm interface {
+	Hash(password string) (string, error)
+	Verify(password, encoded string) (bool, error)
+	// Recognizes reports whether encoded was produced by this algorithm
+	Recognizes(encoded string) bool
+	// Outdated reports whether encoded was produced with weaker parameters than the current ones
+	Outdated(encoded string) bool
+}
+
+// Hasher hashes new passwords with the preferred algorithm and verifies hashes from any known algorithm
+type Hasher interface {
+	Hash(password string) (string, error)
+	// Verify checks password against encoded and reports whether the hash should be replaced
+	Verify(password, encoded string) (ok bool, needsRehash bool, err error)
+}
+
+type hasher struct {
+	preferred  Algorithm
+	algorithms []Algorithm
+}
+
+// NewHasher hashes with preferred and still verifies hashes produced by legacy algorithms
+func NewHasher(preferred Algorithm, legacy ...Algorithm) Hasher {
+	return hasher{
+		preferred:  preferred,
+		algorithms: append([]Algorithm{preferred}, legacy...),
+	}
+}
+
+// New builds the hasher selected by cfg.Algorithm. Argon2id and bcrypt hashes are always verifiable.
+func New(cfg config.Password) (Hasher, error) {
+	argon := NewArgon2id(Argon2idParams{
+		Memory:      uint32(cfg.Argon2id.Memory),
+		Iterations:  uint32(cfg.Argon2id.Iterations),
+		Parallelism: uint8(cfg.Argon2id.Parallelism),
+		SaltLength:  uint32(cfg.Argon2id.SaltLength),
+		KeyLength:   uint32(cfg.Argon2id.KeyLength),
+	})
+	bcrypt := NewBcrypt(int(cfg.BcryptCost))
+
+	switch cfg.Algorithm {
+	case "argon2id":
+		return NewHasher(argon, bcrypt), nil
+	case "bcrypt":
+		return NewHasher(bcrypt, argon), nil
+	default:
+		return nil, fmt.Errorf("unknown password algorithm %q", cfg.Algorithm)
+	}
+}
+
+func (h hasher) Hash(password string) (string, error) {
+	return h.preferred.Hash(password)
+}
+
+func (h hasher) Verify(password, encoded string) (bool, bool, error) {
+	for _, algorithm := range h.algorithms {
+		if !algorithm.Recognizes(encoded) {
+			continue
+		}
+		ok, err := algorithm.Verify(password, encoded)
+		if err != nil || !ok {
+			return false, false, err
+		}
+		return true, algorithm != h.preferred || algorithm.Outdated(encoded), nil
+	}
+
+	// Unknown or empty hashes, e.g. accounts created through an identity provider, never match
+	return false, false, nil
+}
+
+// MatchesAny reports whether password matches any of the encoded hashes, e.g. a user's password history
+func MatchesAny(hasher Hasher, password string, hashes []string) (bool, error) {
+	for _, encoded := range hashes {
+		if encoded == "" {
+			continue
+		}
+		ok, _, err := hasher.Verify(password, encoded)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,206 @@
+package password
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"go.learning/config"
+)
+
+// maxLength bounds the work an attacker can make each hash do
+const maxLength = 256
+
+//go:embed common_passwords.txt
+var commonPasswords string
+
+// WeakPasswordError lists every reason a password was rejected
+type WeakPasswordError struct {
+	Reasons []string `json:"reasons"`
+}
+
+func (e *WeakPasswordError) Error() string {
+	return "password is too weak: " + strings.Join(e.Reasons, "; ")
+}
+
+// Policy screens new passwords before they are hashed
+type Policy interface {
+	// Check rejects weak or breached passwords. attributes are values the password must not contain, such as the email.
+	Check(ctx context.Context, password string, attributes ...string) error
+}
+
+type policy struct {
+	minLength  int
+	minEntropy float64
+	dictionary map[string]bool
+	breached   BreachChecker
+}
+
+// NewPolicy loads the embedded and configured dictionaries and the breached password dataset
+func NewPolicy(cfg config.PasswordPolicy) (Policy, error) {
+	dictionary := make(map[string]bool)
+	addWords(dictionary, commonPasswords)
+	if cfg.DictionaryFile != "" {
+		content, err := os.ReadFile(cfg.DictionaryFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read password dictionary: %w", err)
+		}
+		addWords(dictionary, string(content))
+	}
+
+	var breached BreachChecker
+	if cfg.BreachedDir != "" {
+		checker, err := NewRangeDirectory(cfg.BreachedDir)
+		if err != nil {
+			return nil, err
+		}
+		breached = checker
+	}
+
+	return policy{
+		minLength:  int(cfg.MinLength),
+		minEntropy: cfg.MinEntropy,
+		dictionary: dictionary,
+		breached:   breached,
+	}, nil
+}
+
+func (p policy) Check(ctx context.Context, password string, attributes ...string) error {
+	var reasons []string
+
+	length := utf8.RuneCountInString(password)
+	if length < p.minLength {
+		reasons = append(reasons, fmt.Sprintf("must be at least %d characters", p.minLength))
+	}
+	if length > maxLength {
+		reasons = append(reasons, fmt.Sprintf("must be at most %d characters", maxLength))
+	}
+	if Entropy(password) < p.minEntropy {
+		reasons = append(reasons, "is too predictable; use a longer password or mix more kinds of characters")
+	}
+	if p.inDictionary(password) {
+		reasons = append(reasons, "is a commonly used password")
+	}
+	if containsAttribute(password, attributes) {
+		reasons = append(reasons, "must not contain your name or email")
+	}
+
+	// Only consult the breach dataset for passwords that are otherwise acceptable
+	if len(reasons) == 0 && p.breached != nil {
+		breached, err := p.breached.Breached(ctx, password)
+		if err != nil {
+			return err
+		}
+		if breached {
+			reasons = append(reasons, "has appeared in a data breach")
+		}
+	}
+
+	if len(reasons) > 0 {
+		return &WeakPasswordError{Reasons: reasons}
+	}
+	return nil
+}
+
+// inDictionary also catches common decorations such as "P@ssw0rd!" or "password123"
+func (p policy) inDictionary(password string) bool {
+	normalized := strings.ToLower(password)
+	candidates := []string{
+		normalized,
+		strings.TrimRightFunc(normalized, func(r rune) bool { return unicode.IsDigit(r) || unicode.IsPunct(r) || unicode.IsSymbol(r) }),
+	}
+	for _, candidate := range candidates {
+		if p.dictionary[candidate] || p.dictionary[unleet(candidate)] {
+			return true
+		}
+	}
+	return false
+}
+
+// Entropy estimates the bits of a password from its character pool, discounting repeats and sequences
+func Entropy(password string) float64 {
+	var lower, upper, digit, symbol, other bool
+	effective := 0.0
+	var prev rune
+	for i, r := range password {
+		switch {
+		case unicode.IsLower(r) && r < unicode.MaxASCII:
+			lower = true
+		case unicode.IsUpper(r) && r < unicode.MaxASCII:
+			upper = true
+		case unicode.IsDigit(r) && r < unicode.MaxASCII:
+			digit = true
+		case r < unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+
+		// Repeated and consecutive characters ("aaaa", "1234", "dcba") add little
+		if i > 0 && (r == prev || r == prev+1 || r == prev-1) {
+			effective += 0.25
+		} else {
+			effective++
+		}
+		prev = r
+	}
+
+	pool := 0
+	if lower {
+		pool += 26
+	}
+	if upper {
+		pool += 26
+	}
+	if digit {
+		pool += 10
+	}
+	if symbol {
+		pool += 33
+	}
+	if other {
+		pool += 100
+	}
+	if pool == 0 {
+		return 0
+	}
+	return effective * math.Log2(float64(pool))
+}
+
+func containsAttribute(password string, attributes []string) bool {
+	normalized := strings.ToLower(password)
+	for _, attribute := range attributes {
+		attribute = strings.ToLower(strings.TrimSpace(attribute))
+
+		// Check the local part of an email separately from its domain
+		if local, _, ok := strings.Cut(attribute, "@"); ok {
+			attribute = local
+		}
+		if utf8.RuneCountInString(attribute) >= 3 && strings.Contains(normalized, attribute) {
+			return true
+		}
+	}
+	return false
+}
+
+var leetReplacer = strings.NewReplacer("0", "o", "1", "i", "3", "e", "4", "a", "5", "s", "7", "t", "@", "a", "$", "s", "!", "i")
+
+func unleet(s string) string {
+	return leetReplacer.Replace(s)
+}
+
+func addWords(dictionary map[string]bool, content string) {
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		word := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if word != "" && !strings.HasPrefix(word, "#") {
+			dictionary[word] = true
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/labstack/gommon/log"
+)
+
+// Result describes the state of a key after a request was counted against it
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	ResetAfter time.Duration // time until the oldest counted request leaves the window
+}
+
+// Limiter applies a sliding window of at most limit requests per window to each key
+type Limiter interface {
+	Allow(ctx context.Context, key string, limit int, window time.Duration) (Result, error)
+}
+
+type fallbackLimiter struct {
+	primary  Limiter
+	fallback Limiter
+}
+
+// NewFallbackLimiter uses primary and switches to fallback for any call where primary fails
+func NewFallbackLimiter(primary, fallback Limiter) Limiter {
+	return fallbackLimiter{primary, fallback}
+}
+
+func (l fallbackLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (Result, error) {
+	result, err := l.primary.Allow(ctx, key, limit, window)
+	if err == nil {
+		return result, nil
+	}
+
+	log.Warnf("rate limiter falling back to memory: %v", err)
+	return l.fallback.Allow(ctx, key, limit, window)
+}
@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval bounds how often idle keys are dropped from the memory limiter
+const sweepInterval = time.Minute
+
+type memoryLimiter struct {
+	mu        sync.Mutex
+	requests  map[string][]time.Time
+	windows   map[string]time.Duration
+	lastSweep time.Time
+}
+
+// NewMemoryLimiter counts requests in process memory, so limits apply per instance
+func NewMemoryLimiter() Limiter {
+	return &memoryLimiter{
+		requests:  make(map[string][]time.Time),
+		windows:   make(map[string]time.Duration),
+		lastSweep: time.Now(),
+	}
+}
+
+func (l *memoryLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (Result, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	requests := prune(l.requests[key], now.Add(-window))
+	result := Result{Limit: limit}
+	if len(requests) < limit {
+		requests = append(requests, now)
+		result.Allowed = true
+	}
+	l.requests[key] = requests
+	l.windows[key] = window
+
+	result.Remaining = limit - len(requests)
+	result.ResetAfter = window
+	if len(requests) > 0 {
+		result.ResetAfter = requests[0].Add(window).Sub(now)
+	}
+	return result, nil
+}
+
+// sweep drops keys whose requests have all left their window
+func (l *memoryLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for key, requests := range l.requests {
+		if len(prune(requests, now.Add(-l.windows[key]))) == 0 {
+			delete(l.requests, key)
+			delete(l.windows, key)
+		}
+	}
+}
+
+func prune(requests []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(requests) && !requests[i].After(cutoff) {
+		i++
+	}
+	return requests[i:]
+}
@@ -0,0 +1,64 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+const keyPrefix = "ratelimit:"
+
+// slidingWindow keeps one sorted set entry per request scored by its timestamp in milliseconds.
+// It returns {allowed, remaining, reset_after_ms}.
+var slidingWindow = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+local count = redis.call('ZCARD', key)
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', key, now, ARGV[4])
+	count = count + 1
+	allowed = 1
+end
+redis.call('PEXPIRE', key, window)
+
+local reset = window
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+return {allowed, limit - count, reset}
+`)
+
+type redisLimiter struct {
+	redisClient *redis.Client
+}
+
+// NewRedisLimiter shares counters between every instance connected to the same Redis
+func NewRedisLimiter(redisClient *redis.Client) Limiter {
+	return redisLimiter{redisClient}
+}
+
+func (l redisLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (Result, error) {
+	now := time.Now().UnixMilli()
+	values, err := slidingWindow.Run(ctx, l.redisClient, []string{keyPrefix + key},
+		now, window.Milliseconds(), limit, uuid.New().String(),
+	).Int64Slice()
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to evaluate rate limit: %w", err)
+	}
+
+	return Result{
+		Allowed:    values[0] == 1,
+		Limit:      limit,
+		Remaining:  int(values[1]),
+		ResetAfter: time.Duration(values[2]) * time.Millisecond,
+	}, nil
+}
@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"net/url"
+	"strings"
+)
+
+// ValidOriginPattern reports whether pattern is "*", an origin such as https://example.com:8443,
+// or an origin whose host starts with a "*." wildcard such as https://*.example.com
+func ValidOriginPattern(pattern string) bool {
+	if pattern == "*" {
+		return true
+	}
+	u, err := url.Parse(pattern)
+	if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return false
+	}
+	host := strings.TrimPrefix(u.Hostname(), "*.")
+	return host != "" && !strings.Contains(host, "*")
+}
+
+// MatchOrigin reports whether origin matches any of the patterns accepted by ValidOriginPattern.
+// A wildcard matches one or more subdomain labels but never the bare domain.
+func MatchOrigin(patterns []string, origin string) bool {
+	o, err := url.Parse(origin)
+	if err != nil || o.Scheme == "" || o.Host == "" {
+		return false
+	}
+
+	for _, pattern := range patterns {
+		if pattern == "*" {
+			return true
+		}
+		p, err := url.Parse(pattern)
+		if err != nil || !strings.EqualFold(p.Scheme, o.Scheme) || p.Port() != o.Port() {
+			continue
+		}
+
+		patternHost := strings.ToLower(p.Hostname())
+		originHost := strings.ToLower(o.Hostname())
+		if suffix, ok := strings.CutPrefix(patternHost, "*."); ok {
+			if strings.HasSuffix(originHost, "."+suffix) {
+				return true
+			}
+			continue
+		}
+		if patternHost == originHost {
+			return true
+		}
+	}
+	return false
+}
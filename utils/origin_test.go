@@ -0,0 +1,70 @@
+package utils
+
+import "testing"
+
+func TestMatchOrigin(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		origin   string
+		want     bool
+	}{
+		{"any origin", []string{"*"}, "https://example.com", true},
+		{"exact match", []string{"https://example.com"}, "https://example.com", true},
+		{"case insensitive", []string{"HTTPS://Example.COM"}, "https://example.com", true},
+		{"second pattern", []string{"https://a.example", "https://b.example"}, "https://b.example", true},
+		{"other host", []string{"https://example.com"}, "https://example.org", false},
+		{"host suffix", []string{"https://example.com"}, "https://evil-example.com", false},
+		{"other scheme", []string{"https://example.com"}, "http://example.com", false},
+		{"explicit port", []string{"https://example.com:8443"}, "https://example.com:8443", true},
+		{"other port", []string{"https://example.com:8443"}, "https://example.com:9443", false},
+		{"missing port", []string{"https://example.com:8443"}, "https://example.com", false},
+		{"default port is not implied", []string{"https://example.com"}, "https://example.com:443", false},
+		{"wildcard subdomain", []string{"https://*.example.com"}, "https://app.example.com", true},
+		{"wildcard nested subdomain", []string{"https://*.example.com"}, "https://a.b.example.com", true},
+		{"wildcard bare domain", []string{"https://*.example.com"}, "https://example.com", false},
+		{"wildcard suffix only", []string{"https://*.example.com"}, "https://evilexample.com", false},
+		{"wildcard other scheme", []string{"https://*.example.com"}, "http://app.example.com", false},
+		{"wildcard with port", []string{"https://*.example.com:8443"}, "https://app.example.com:8443", true},
+		{"no patterns", nil, "https://example.com", false},
+		{"null origin", []string{"https://example.com"}, "null", false},
+		{"empty origin", []string{"*"}, "", false},
+		{"origin without scheme", []string{"https://example.com"}, "example.com", false},
+		{"malformed origin", []string{"https://example.com"}, "https://exa mple.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchOrigin(tt.patterns, tt.origin); got != tt.want {
+				t.Errorf("MatchOrigin(%q, %q) = %t, want %t", tt.patterns, tt.origin, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidOriginPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    bool
+	}{
+		{"*", true},
+		{"https://example.com", true},
+		{"https://example.com:8443", true},
+		{"https://*.example.com", true},
+		{"example.com", false},
+		{"https://", false},
+		{"https://example.com/", false},
+		{"https://example.com?a=b", false},
+		{"https://example.com#top", false},
+		{"https://user@example.com", false},
+		{"https://*", false},
+		{"https://*.*.example.com", false},
+		{"https://app.*.example.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			if got := ValidOriginPattern(tt.pattern); got != tt.want {
+				t.Errorf("ValidOriginPattern(%q) = %t, want %t", tt.pattern, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,18 +0,0 @@
-package utils
-
-import "golang.org/x/crypto/bcrypt"
-
-// GenerateHashedPassword generates a hashed password using bcrypt
-func GenerateHashedPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
-	}
-	return string(hashedPassword), nil
-}
-
-// ValidatePassword validates the plain text password against the hashed password
-func ValidatePassword(password, hashedPassword string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-	return err == nil
-}
@@ -2,24 +2,187 @@ package utils
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 )
 
-func StoreTokenInRedis(redisClient *redis.Client, sessionID, token string) error {
-	// Set the expiration time for the token
-	err := redisClient.Set(context.Background(), sessionID, token, time.Hour*24).Err()
+// activeSessionsKey is a sorted set of session IDs scored by their expiry time
+const activeSessionsKey = "sessions:active"
+
+// subjectSessionsPrefix indexes each subject's session IDs, scored by the expiry of their refresh tokens
+const subjectSessionsPrefix = "sessions:subject:"
+
+// oauthGrantPrefix records the OAuth client and scope of sessions whose tokens were issued to a client
+const oauthGrantPrefix = "oauth:grant:"
+
+// revokedSessionPrefix marks sessions whose refresh tokens must no longer be accepted
+const revokedSessionPrefix = "sessions:revoked:"
+
+// StoreTokenInRedis stores the session's access token until the token itself expires
+func StoreTokenInRedis(ctx context.Context, redisClient *redis.Client, sessionID, token string, expiresAt time.Time) error {
+	_, err := redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, sessionID, token, time.Until(expiresAt))
+		pipe.ZAdd(ctx, activeSessionsKey, &redis.Z{Score: float64(expiresAt.Unix()), Member: sessionID})
+		return nil
+	})
 	return err
 }
 
-func DeleteTokenInRedis(redisClient *redis.Client, sessionID string) error {
+func DeleteTokenInRedis(ctx context.Context, redisClient *redis.Client, sessionID string) error {
 	// Delete the token from Redis
-	err := redisClient.Del(context.Background(), sessionID).Err()
+	_, err := redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, sessionID)
+		pipe.ZRem(ctx, activeSessionsKey, sessionID)
+		return nil
+	})
 	return err
 }
 
-func CheckSessionInRedis(redisClient *redis.Client, sessionID string) (string, error) {
+// RevokeSessionInRedis deletes the session and rejects its refresh tokens until they have all expired
+func RevokeSessionInRedis(ctx context.Context, redisClient *redis.Client, sessionID string, until time.Time) error {
+	_, err := redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, sessionID)
+		pipe.ZRem(ctx, activeSessionsKey, sessionID)
+		// Once every refresh token has expired there is nothing left to reject
+		if ttl := time.Until(until); ttl > 0 {
+			pipe.Set(ctx, revokedSessionPrefix+sessionID, 1, ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+// trackSession adds a session to a subject's index, prunes expired ones and keeps the index until its
+// longest-lived session expires. Sessions can outlive newer ones when the refresh TTL is shortened, and a
+// session's score only grows since refresh tokens issued earlier stay valid.
+var trackSession = redis.NewScript(`
+local key = KEYS[1]
+redis.call('ZADD', key, 'GT', ARGV[1], ARGV[2])
+redis.call('ZREMRANGEBYSCORE', key, '-inf', ARGV[3])
+local last = redis.call('ZRANGE', key, -1, -1, 'WITHSCORES')
+if last[2] then
+	redis.call('EXPIREAT', key, last[2])
+end
+return 1
+`)
+
+// TrackSession adds the session to the subject's index until its refresh tokens expire
+func TrackSession(ctx context.Context, redisClient *redis.Client, subject, sessionID string, until time.Time) error {
+	return trackSession.Run(ctx, redisClient, []string{subjectSessionsPrefix + subject},
+		until.Unix(), sessionID, time.Now().Unix()).Err()
+}
+
+// RevokeSubjectSessions revokes every session of the subject, e.g. when a user is deleted. Each session stays
+// revoked until its own refresh tokens expire, as recorded in the index, whatever the refresh TTL is now.
+func RevokeSubjectSessions(ctx context.Context, redisClient *redis.Client, subject string) error {
+	key := subjectSessionsPrefix + subject
+	sessions, err := redisClient.ZRangeWithScores(ctx, key, 0, -1).Result()
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		sessionID := fmt.Sprintf("%s", session.Member)
+		if err := RevokeSessionInRedis(ctx, redisClient, sessionID, time.Unix(int64(session.Score), 0)); err != nil {
+			return err
+		}
+	}
+	return redisClient.Del(ctx, key).Err()
+}
+
+// GetSessionExpiry returns when the session's last refresh token expires, or the zero time when it isn't indexed
+func GetSessionExpiry(ctx context.Context, redisClient *redis.Client, subject, sessionID string) (time.Time, error) {
+	score, err := redisClient.ZScore(ctx, subjectSessionsPrefix+subject, sessionID).Result()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(score), 0), nil
+}
+
+// GetSubjectSessions returns the subject's live sessions scored by the expiry of their refresh tokens
+func GetSubjectSessions(ctx context.Context, redisClient *redis.Client, subject string) ([]redis.Z, error) {
+	return redisClient.ZRangeByScoreWithScores(ctx, subjectSessionsPrefix+subject, &redis.ZRangeBy{
+		Min: fmt.Sprintf("%d", time.Now().Unix()),
+		Max: "+inf",
+	}).Result()
+}
+
+func IsSessionRevoked(ctx context.Context, redisClient *redis.Client, sessionID string) (bool, error) {
+	count, err := redisClient.Exists(ctx, revokedSessionPrefix+sessionID).Result()
+	return count > 0, err
+}
+
+func CheckSessionInRedis(ctx context.Context, redisClient *redis.Client, sessionID string) (string, error) {
 	// Check if the session ID exists in Redis
-	return redisClient.Get(context.Background(), sessionID).Result()
+	return redisClient.Get(ctx, sessionID).Result()
+}
+
+// CountActiveSessions prunes expired entries from the session index and returns the number of live sessions
+func CountActiveSessions(ctx context.Context, redisClient *redis.Client) (int64, error) {
+	now := fmt.Sprintf("%d", time.Now().Unix())
+	var card *redis.IntCmd
+	_, err := redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(ctx, activeSessionsKey, "-inf", now)
+		card = pipe.ZCard(ctx, activeSessionsKey)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return card.Val(), nil
+}
+
+// OAuthGrant records which client a session was issued to and with which scope
+type OAuthGrant struct {
+	ClientID string `json:"client_id"`
+	Scope    string `json:"scope"`
+}
+
+// HasScope reports whether the grant includes scope
+func (g OAuthGrant) HasScope(scope string) bool {
+	for _, s := range strings.Fields(g.Scope) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// StoreOAuthGrant records the grant behind a session's tokens until they expire
+func StoreOAuthGrant(ctx context.Context, redisClient *redis.Client, sessionID string, grant OAuthGrant, ttl time.Duration) error {
+	payload, err := json.Marshal(grant)
+	if err != nil {
+		return err
+	}
+	err = redisClient.Set(ctx, oauthGrantPrefix+sessionID, payload, ttl).Err()
+	if err != nil {
+		return fmt.Errorf("failed to store grant: %w", err)
+	}
+	return nil
+}
+
+// GetOAuthGrant returns the grant behind a session, or nil for sessions of a first-party login
+func GetOAuthGrant(ctx context.Context, redisClient *redis.Client, sessionID string) (*OAuthGrant, error) {
+	payload, err := redisClient.Get(ctx, oauthGrantPrefix+sessionID).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load grant: %w", err)
+	}
+	var grant OAuthGrant
+	if err := json.Unmarshal(payload, &grant); err != nil {
+		return nil, fmt.Errorf("failed to decode grant: %w", err)
+	}
+	return &grant, nil
+}
+
+func DeleteOAuthGrant(ctx context.Context, redisClient *redis.Client, sessionID string) error {
+	return redisClient.Del(ctx, oauthGrantPrefix+sessionID).Err()
 }
@@ -7,40 +7,83 @@ import (
 	"github.com/dgrijalva/jwt-go"
 )
 
-// GenerateJWT generates a JWT token
-func GenerateJWT(secretKey string, sessionId string, userID string) (string, string, int64, error) {
+// Token types carried in the "tokenType" claim
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+)
+
+// ScopePasswordChange restricts a token to the change-password endpoint
+const ScopePasswordChange = "password-change"
+
+// GenerateRestrictedJWT generates an access token limited to scope. It has no refresh token.
+func GenerateRestrictedJWT(secretKey string, sessionId string, userID string, scope string, expiresAt time.Time) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"userID":           userID,
+		"sessionId":        sessionId,
+		"sessionStartedAt": time.Now().Unix(),
+		"tokenType":        TokenTypeAccess,
+		"scope":            scope,
+		"exp":              expiresAt.Unix(),
+	})
+	return token.SignedString([]byte(secretKey))
+}
+
+// GenerateJWT generates an access token and a refresh token for the session.
+// sessionStartedAt is carried in both tokens so refreshes can enforce the absolute session lifetime.
+func GenerateJWT(secretKey string, sessionId string, userID string, sessionStartedAt, accessExpiresAt, refreshExpiresAt time.Time) (string, string, error) {
 
 	// Create the token
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"userID":    userID,
-		"sessionId": sessionId,
-		"exp":       time.Now().Add(time.Hour * 24).Unix(), // Token expires in 24 hours
+		"userID":           userID,
+		"sessionId":        sessionId,
+		"sessionStartedAt": sessionStartedAt.Unix(),
+		"tokenType":        TokenTypeAccess,
+		"exp":              accessExpiresAt.Unix(),
 	})
 
 	// Sign the token with the secret key
 	signedToken, err := token.SignedString([]byte(secretKey))
 	if err != nil {
-		return "", "", 0, err
+		return "", "", err
 	}
 
-	// Create a refresh token 7 days from now
+	// Create a refresh token
 	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"userID":    userID,
-		"sessionId": sessionId,
-		"exp":       time.Now().Add(time.Hour * 24 * 7).Unix(), // Refresh token expires in 7 days
+		"userID":           userID,
+		"sessionId":        sessionId,
+		"sessionStartedAt": sessionStartedAt.Unix(),
+		"tokenType":        TokenTypeRefresh,
+		"exp":              refreshExpiresAt.Unix(),
 	})
 	refreshTokenString, err := refreshToken.SignedString([]byte(secretKey))
 	if err != nil {
-		return "", "", 0, err
+		return "", "", err
 	}
 
-	// Create the expiration time
-	expiresAt := time.Now().Add(time.Hour * 24).Unix()
-	if err != nil {
-		return "", "", 0, err
+	return signedToken, refreshTokenString, nil
+}
+
+// SessionStartedAt returns when the session behind the claims began, falling back to fallback for older tokens
+func SessionStartedAt(claims jwt.MapClaims, fallback time.Time) time.Time {
+	if startedAt, ok := claims["sessionStartedAt"].(float64); ok {
+		return time.Unix(int64(startedAt), 0)
 	}
+	return fallback
+}
+
+// ExpiresAt returns the token's exp claim, or the zero time when it has none
+func ExpiresAt(claims jwt.MapClaims) time.Time {
+	if exp, ok := claims["exp"].(float64); ok {
+		return time.Unix(int64(exp), 0)
+	}
+	return time.Time{}
+}
 
-	return signedToken, refreshTokenString, expiresAt, nil
+// TokenType returns whether the claims belong to an access or a refresh token, or "" for older tokens
+func TokenType(claims jwt.MapClaims) string {
+	tokenType, _ := claims["tokenType"].(string)
+	return tokenType
 }
 
 func ValidateJWT(secretKey string, tokenString string) (*jwt.MapClaims, error) {
@@ -0,0 +1,144 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// sheet is the part of a worksheet the writer fills in
+type sheet struct {
+	Rows []struct {
+		R     int `xml:"r,attr"`
+		Cells []struct {
+			Type string `xml:"t,attr"`
+			Text string `xml:"is>t"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+type workbook struct {
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+func TestWriter(t *testing.T) {
+	tests := []struct {
+		name      string
+		sheetName string
+		rows      [][]string
+	}{
+		{"no rows", "Users", nil},
+		{"header only", "Users", [][]string{{"id", "email"}}},
+		{"several rows", "Users", [][]string{{"id", "email"}, {"1", "a@example.com"}, {"2", "b@example.com"}}},
+		{"empty cells", "Users", [][]string{{"", "x", ""}, {}}},
+		{"markup in cells", "Users", [][]string{{`<b>&"quoted"'</b>`, "]]>"}}},
+		{"whitespace and unicode", "Users", [][]string{{"  padded  ", "line\nbreak", "tab\there", "Zoë 日本"}}},
+		{"markup in sheet name", `A&B <"x">`, [][]string{{"1"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, tt.sheetName)
+			if err != nil {
+				t.Fatalf("NewWriter: %v", err)
+			}
+			for _, row := range tt.rows {
+				if err := w.WriteRow(row); err != nil {
+					t.Fatalf("WriteRow: %v", err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+
+			parts := readParts(t, buf.Bytes())
+			for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/_rels/workbook.xml.rels", "xl/workbook.xml", "xl/worksheets/sheet1.xml"} {
+				body, ok := parts[name]
+				if !ok {
+					t.Fatalf("missing part %s", name)
+				}
+				if err := wellFormed(body); err != nil {
+					t.Errorf("part %s is not well-formed XML: %v", name, err)
+				}
+			}
+
+			var wb workbook
+			if err := xml.Unmarshal(parts["xl/workbook.xml"], &wb); err != nil {
+				t.Fatalf("decode workbook: %v", err)
+			}
+			if len(wb.Sheets) != 1 || wb.Sheets[0].Name != tt.sheetName {
+				t.Errorf("sheets = %+v, want one named %q", wb.Sheets, tt.sheetName)
+			}
+
+			var s sheet
+			if err := xml.Unmarshal(parts["xl/worksheets/sheet1.xml"], &s); err != nil {
+				t.Fatalf("decode sheet: %v", err)
+			}
+			if len(s.Rows) != len(tt.rows) {
+				t.Fatalf("got %d rows, want %d", len(s.Rows), len(tt.rows))
+			}
+			for n, row := range s.Rows {
+				if row.R != n+1 {
+					t.Errorf("row %d is numbered %d", n+1, row.R)
+				}
+				got := make([]string, 0, len(row.Cells))
+				for _, cell := range row.Cells {
+					if cell.Type != "inlineStr" {
+						t.Errorf("row %d has a cell of type %q, want inlineStr", n+1, cell.Type)
+					}
+					got = append(got, cell.Text)
+				}
+				want := tt.rows[n]
+				if want == nil {
+					want = []string{}
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("row %d = %q, want %q", n+1, got, want)
+				}
+			}
+		})
+	}
+}
+
+// readParts opens the workbook as a zip archive and returns the content of each part
+func readParts(t *testing.T, data []byte) map[string][]byte {
+	t.Helper()
+	z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("open workbook: %v", err)
+	}
+	parts := make(map[string][]byte, len(z.File))
+	for _, f := range z.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("open part %s: %v", f.Name, err)
+		}
+		body, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("read part %s: %v", f.Name, err)
+		}
+		parts[f.Name] = body
+	}
+	return parts
+}
+
+// wellFormed decodes every token of the document
+func wellFormed(body []byte) error {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		_, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}